@@ -0,0 +1,85 @@
+package cmd
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/bhojpur/middleware/pkg/engine"
+	// registers the authentication middlewares' factories
+	_ "github.com/bhojpur/middleware/pkg/engine/auth"
+)
+
+// serveMiddlewares serves the configured middleware stack in front of the
+// upstream service, the stack follows changes of its config file
+func serveMiddlewares() error {
+	var handler http.Handler = http.NotFoundHandler()
+	if webCmdOpts.Upstream != "" {
+		upstream, err := url.Parse(webCmdOpts.Upstream)
+		if err != nil {
+			return fmt.Errorf("invalid upstream %s: %w", webCmdOpts.Upstream, err)
+		}
+		handler = httputil.NewSingleHostReverseProxy(upstream)
+	}
+
+	watcher := &engine.ConfigWatcher{
+		Path:  webCmdOpts.MiddlewareConfig,
+		Stack: engine.DefaultMiddlewareStack,
+	}
+	if err := watcher.Load(); err != nil {
+		return fmt.Errorf("cannot load middleware config %s: %w", webCmdOpts.MiddlewareConfig, err)
+	}
+
+	liveHandler, err := engine.ApplyLive(handler)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go watcher.Run(ctx)
+
+	server := &http.Server{Addr: webCmdOpts.Listen, Handler: liveHandler}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	log.WithField("addr", webCmdOpts.Listen).WithField("middlewares", engine.DefaultMiddlewareStack.String()).Info("serving middlewares")
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -42,6 +42,8 @@ import (
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/tools/portforward"
 	"k8s.io/client-go/transport/spdy"
+
+	"github.com/bhojpur/middleware/pkg/engine"
 )
 
 var (
@@ -55,6 +57,9 @@ var webCmdOpts struct {
 	K8sLabelSelector string
 	K8sPodPort       string
 	DialMode         string
+	Listen           string
+	Upstream         string
+	MiddlewareConfig string
 }
 
 // webCmd represents the base command when called without any subcommands
@@ -66,6 +71,12 @@ var webCmd = &cobra.Command{
 			log.SetLevel(log.DebugLevel)
 			log.Debug("verbose logging enabled")
 		}
+
+		if webCmdOpts.MiddlewareConfig != "" {
+			if err := serveMiddlewares(); err != nil {
+				log.WithError(err).Fatal("cannot serve middlewares")
+			}
+		}
 	},
 }
 
@@ -105,10 +116,17 @@ func init() {
 	if dialMode == "" {
 		dialMode = string(dialModeHost)
 	}
+	middlewareListen := os.Getenv("MIDDLEWARE_LISTEN")
+	if middlewareListen == "" {
+		middlewareListen = ":8080"
+	}
 
 	webCmd.PersistentFlags().StringVar(&webCmdOpts.DialMode, "dial-mode", dialMode, "dial mode that determines how we connect to Bhojpur Middleware. Valid values are \"host\" or \"kubernetes\" (defaults to MIDDLEWARE_DIAL_MODE env var).")
 	webCmd.PersistentFlags().StringVar(&webCmdOpts.Host, "host", middlewareHost, "[host dial mode] Bhojpur Middleware host to talk to (defaults to MIDDLEWARE_HOST env var)")
 	webCmd.PersistentFlags().StringVar(&webCmdOpts.Kubeconfig, "kubeconfig", middlewareKubeconfig, "[kubernetes dial mode] kubeconfig file to use (defaults to KUEBCONFIG env var)")
+	webCmd.PersistentFlags().StringVar(&webCmdOpts.MiddlewareConfig, "middleware-config", os.Getenv("MIDDLEWARE_CONFIG"), "YAML or JSON middleware stack config to serve, it is reloaded when changed (defaults to MIDDLEWARE_CONFIG env var)")
+	webCmd.PersistentFlags().StringVar(&webCmdOpts.Listen, "listen", middlewareListen, "address to serve the middleware stack on (defaults to MIDDLEWARE_LISTEN env var)")
+	webCmd.PersistentFlags().StringVar(&webCmdOpts.Upstream, "upstream", os.Getenv("MIDDLEWARE_UPSTREAM"), "URL of the service behind the middleware stack, requests get 404 if it is blank (defaults to MIDDLEWARE_UPSTREAM env var)")
 	webCmd.PersistentFlags().StringVar(&webCmdOpts.K8sNamespace, "k8s-namespace", middlewareNamespace, "[kubernetes dial mode] Kubernetes namespace in which to look for the Bhojpur Midleware pods (defaults to MIDDLEWARE_K8S_NAMESPACE env var, or configured kube context namespace)")
 	// The following are such specific flags that really only matters if one doesn't use the stock helm charts.
 	// They can still be set using an env var, but there's no need to clutter the CLI with them.
@@ -121,11 +139,25 @@ type closableGrpcClientConnInterface interface {
 	io.Closer
 }
 
+// dialOptions returns options of grpc.Dial, with the default client interceptors
+func dialOptions() ([]grpc.DialOption, error) {
+	interceptors, err := engine.DialOptions()
+	if err != nil {
+		return nil, fmt.Errorf("invalid client interceptors: %w", err)
+	}
+	return append([]grpc.DialOption{grpc.WithInsecure()}, interceptors...), nil
+}
+
 func dial() (res closableGrpcClientConnInterface) {
-	var err error
+	var (
+		err  error
+		opts []grpc.DialOption
+	)
 	switch webCmdOpts.DialMode {
 	case dialModeHost:
-		res, err = grpc.Dial(webCmdOpts.Host, grpc.WithInsecure())
+		if opts, err = dialOptions(); err == nil {
+			res, err = grpc.Dial(webCmdOpts.Host, opts...)
+		}
 	case dialModeKubernetes:
 		res, err = dialKubernetes()
 	default:
@@ -168,7 +200,13 @@ func dialKubernetes() (closableGrpcClientConnInterface, error) {
 	case <-readychan:
 	}
 
-	res, err := grpc.Dial(fmt.Sprintf("localhost:%d", localPort), grpc.WithInsecure())
+	opts, err := dialOptions()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	res, err := grpc.Dial(fmt.Sprintf("localhost:%d", localPort), opts...)
 	if err != nil {
 		cancel()
 		return nil, fmt.Errorf("cannot dial forwarded connection: %w", err)
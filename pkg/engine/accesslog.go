@@ -0,0 +1,341 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// AccessLogFormat output format of AccessLog
+type AccessLogFormat string
+
+// Output formats of AccessLog
+const (
+	// AccessLogText logs requests with the logger's own formatter
+	AccessLogText AccessLogFormat = "text"
+	// AccessLogJSON logs requests as JSON objects
+	AccessLogJSON AccessLogFormat = "json"
+	// AccessLogCombined logs requests as lines of the Apache combined log format
+	AccessLogCombined AccessLogFormat = "combined"
+)
+
+// Fields logged by AccessLog
+const (
+	AccessLogMethod    = "method"
+	AccessLogPath      = "path"
+	AccessLogProto     = "proto"
+	AccessLogHost      = "host"
+	AccessLogStatus    = "status"
+	AccessLogBytes     = "bytes"
+	AccessLogLatency   = "latency"
+	AccessLogRemoteIP  = "remote_ip"
+	AccessLogRequestID = "request_id"
+	AccessLogReferer   = "referer"
+	AccessLogUserAgent = "user_agent"
+)
+
+// AccessLogFields default fields logged by AccessLog
+var AccessLogFields = []string{AccessLogMethod, AccessLogPath, AccessLogStatus, AccessLogBytes, AccessLogLatency, AccessLogRemoteIP, AccessLogRequestID}
+
+// AccessLogOptions options of AccessLog
+type AccessLogOptions struct {
+	// Logger is log.StandardLogger() if it is nil
+	Logger *log.Logger
+	// Level of logged requests, log.InfoLevel if it is zero, which is
+	// log.PanicLevel, logrus panics when logging at it. Responses with 5xx
+	// status are logged as errors
+	Level  log.Level
+	Format AccessLogFormat
+	// Fields to log, AccessLogFields if it is empty
+	Fields []string
+	// SampleRate logs a share of requests between 0 and 1, all requests are
+	// logged if it is 0, responses with 5xx status are always logged
+	SampleRate float64
+	// SkipPaths path prefixes of requests not to log, like health checks,
+	// matched by whole segments against the cleaned path like Middleware.Paths
+	SkipPaths []string
+	Skip      func(*http.Request) bool
+	// TrustedProxies number of reverse proxies in front of the server, the
+	// remote IP is taken from the X-Forwarded-For entry added by the outermost
+	// of them, or from X-Real-IP, proxy headers are ignored if it is zero
+	TrustedProxies int
+}
+
+// AccessLog returns a middleware logging requests with logrus
+func AccessLog(options AccessLogOptions) Middleware {
+	var (
+		logger = accessLogger(options)
+		fields = options.Fields
+	)
+
+	if len(fields) == 0 {
+		fields = AccessLogFields
+	}
+
+	if options.Level == log.PanicLevel {
+		options.Level = log.InfoLevel
+	}
+
+	return Middleware{
+		Name:        AccessLogName,
+		InsertAfter: []string{RequestIDName},
+		Handler: func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if matchAny(options.SkipPaths, req.URL.Path, matchPathPrefix) || (options.Skip != nil && options.Skip(req)) {
+					next.ServeHTTP(w, req)
+					return
+				}
+
+				var (
+					start    = time.Now()
+					recorder = newResponseRecorder(w)
+				)
+
+				// log from a defer, so requests panicking in later handlers are
+				// logged too, before the panic reaches an outer Recover
+				completed := false
+				defer func() {
+					status := recorder.Status()
+					if !completed && !recorder.Written() {
+						status = http.StatusInternalServerError
+					}
+
+					if status < http.StatusInternalServerError && options.SampleRate > 0 && options.SampleRate < 1 && rand.Float64() >= options.SampleRate {
+						return
+					}
+
+					entry := accessLogEntry{req: req, recorder: recorder, status: status, start: start, latency: time.Since(start), trustedProxies: options.TrustedProxies}
+					level := options.Level
+					if status >= http.StatusInternalServerError {
+						level = log.ErrorLevel
+					}
+
+					logEntry := logger.WithFields(entry.fields(fields))
+					if options.Format == AccessLogCombined {
+						logEntry.Log(level, entry.combined())
+					} else if !completed {
+						logEntry.Log(level, "request panicked")
+					} else {
+						logEntry.Log(level, "request completed")
+					}
+				}()
+
+				next.ServeHTTP(recorder, req)
+				completed = true
+			})
+		},
+	}
+}
+
+// accessLogger returns the logger of options, with a formatter for its format
+func accessLogger(options AccessLogOptions) *log.Logger {
+	logger := options.Logger
+	if logger == nil {
+		logger = log.StandardLogger()
+	}
+
+	var formatter log.Formatter
+	switch options.Format {
+	case AccessLogJSON:
+		formatter = &log.JSONFormatter{}
+	case AccessLogCombined:
+		formatter = combinedFormatter{}
+	default:
+		return logger
+	}
+
+	return &log.Logger{
+		Out:          logger.Out,
+		Hooks:        logger.Hooks,
+		Formatter:    formatter,
+		ReportCaller: logger.ReportCaller,
+		Level:        logger.GetLevel(),
+		ExitFunc:     logger.ExitFunc,
+	}
+}
+
+// combinedFormatter writes the message only, which is a line of the combined log format
+type combinedFormatter struct{}
+
+func (combinedFormatter) Format(entry *log.Entry) ([]byte, error) {
+	return []byte(entry.Message + "\n"), nil
+}
+
+type accessLogEntry struct {
+	req            *http.Request
+	recorder       *responseRecorder
+	status         int
+	start          time.Time
+	latency        time.Duration
+	trustedProxies int
+}
+
+func (entry accessLogEntry) fields(names []string) log.Fields {
+	fields := log.Fields{}
+	for _, name := range names {
+		switch name {
+		case AccessLogMethod:
+			fields[name] = entry.req.Method
+		case AccessLogPath:
+			fields[name] = entry.req.URL.Path
+		case AccessLogProto:
+			fields[name] = entry.req.Proto
+		case AccessLogHost:
+			fields[name] = entry.req.Host
+		case AccessLogStatus:
+			fields[name] = entry.status
+		case AccessLogBytes:
+			fields[name] = entry.recorder.bytes
+		case AccessLogLatency:
+			fields[name] = entry.latency.String()
+		case AccessLogRemoteIP:
+			fields[name] = remoteIP(entry.req, entry.trustedProxies)
+		case AccessLogRequestID:
+			fields[name] = entry.requestID()
+		case AccessLogReferer:
+			fields[name] = entry.req.Referer()
+		case AccessLogUserAgent:
+			fields[name] = entry.req.UserAgent()
+		}
+	}
+	return fields
+}
+
+func (entry accessLogEntry) requestID() string {
+	if id := GetRequestID(entry.req); id != "" {
+		return id
+	}
+
+	if id := entry.recorder.Header().Get(RequestIDHeader); id != "" {
+		return id
+	}
+	return entry.req.Header.Get(RequestIDHeader)
+}
+
+// combined returns the request as a line of the Apache combined log format
+func (entry accessLogEntry) combined() string {
+	user := "-"
+	if entry.req.URL.User != nil {
+		user = entry.req.URL.User.Username()
+	} else if username, _, ok := entry.req.BasicAuth(); ok && username != "" {
+		user = username
+	}
+
+	size := "-"
+	if entry.recorder.bytes > 0 {
+		size = fmt.Sprint(entry.recorder.bytes)
+	}
+
+	return fmt.Sprintf("%s - %s [%s] %q %d %s %q %q",
+		remoteIP(entry.req, entry.trustedProxies),
+		user,
+		entry.start.Format("02/Jan/2006:15:04:05 -0700"),
+		fmt.Sprintf("%s %s %s", entry.req.Method, entry.req.RequestURI, entry.req.Proto),
+		entry.status,
+		size,
+		entry.req.Referer(),
+		entry.req.UserAgent(),
+	)
+}
+
+// remoteIP returns IP of the client, from proxy headers if trustedProxies is
+// positive. Clients could send any X-Forwarded-For, each proxy appends the
+// address it got the request from, so only the last trustedProxies entries
+// are genuine, the earliest of them is the client
+func remoteIP(req *http.Request, trustedProxies int) string {
+	if trustedProxies > 0 {
+		var forwarded []string
+		for _, value := range req.Header.Values("X-Forwarded-For") {
+			for _, ip := range strings.Split(value, ",") {
+				if ip = strings.TrimSpace(ip); ip != "" {
+					forwarded = append(forwarded, ip)
+				}
+			}
+		}
+
+		if len(forwarded) > 0 {
+			if trustedProxies > len(forwarded) {
+				trustedProxies = len(forwarded)
+			}
+			return forwarded[len(forwarded)-trustedProxies]
+		}
+
+		if realIP := req.Header.Get("X-Real-IP"); realIP != "" {
+			return strings.TrimSpace(realIP)
+		}
+	}
+
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		return host
+	}
+	return req.RemoteAddr
+}
+
+func init() {
+	RegisterFactory(AccessLogName, Factory{
+		Description: "logs requests with logrus",
+		Params: []Param{
+			{Name: "format", Type: StringParam, Default: string(AccessLogText), Description: "text, json or combined"},
+			{Name: "level", Type: StringParam, Default: "info"},
+			{Name: "fields", Type: StringListParam},
+			{Name: "sample_rate", Type: FloatParam},
+			{Name: "skip_paths", Type: StringListParam},
+			{Name: "trusted_proxies", Type: IntParam, Description: "number of reverse proxies adding X-Forwarded-For"},
+		},
+		New: func(params Params) (Middleware, error) {
+			format := AccessLogFormat(params.String("format"))
+			switch format {
+			case AccessLogText, AccessLogJSON, AccessLogCombined:
+			default:
+				return Middleware{}, fmt.Errorf("unknown access log format %v", format)
+			}
+
+			level, err := log.ParseLevel(params.String("level"))
+			if err != nil {
+				return Middleware{}, err
+			}
+
+			if level == log.PanicLevel {
+				return Middleware{}, fmt.Errorf("access log level %v isn't supported, logging at it panics", level)
+			}
+
+			if rate := params.Float("sample_rate"); rate < 0 || rate > 1 {
+				return Middleware{}, fmt.Errorf("sample rate %v should be between 0 and 1", rate)
+			}
+
+			return AccessLog(AccessLogOptions{
+				Level:          level,
+				Format:         format,
+				Fields:         params.Strings("fields"),
+				SampleRate:     params.Float("sample_rate"),
+				SkipPaths:      params.Strings("skip_paths"),
+				TrustedProxies: params.Int("trusted_proxies"),
+			}), nil
+		},
+	})
+}
@@ -0,0 +1,103 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func serveAccessLog(options AccessLogOptions, target string, status int) *bytes.Buffer {
+	var (
+		buf     = &bytes.Buffer{}
+		handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(status)
+			w.Write([]byte("hello"))
+		})
+	)
+
+	options.Logger = log.New()
+	options.Logger.Out = buf
+
+	req := httptest.NewRequest("GET", target, nil)
+	req.Header.Set("X-Request-ID", "abc")
+	req.Header.Set("User-Agent", "tester")
+	AccessLog(options).Handler(handler).ServeHTTP(httptest.NewRecorder(), req)
+	return buf
+}
+
+func TestAccessLogJSON(t *testing.T) {
+	var fields map[string]interface{}
+	buf := serveAccessLog(AccessLogOptions{Format: AccessLogJSON}, "/users?page=2", http.StatusCreated)
+	if err := json.Unmarshal(buf.Bytes(), &fields); err != nil {
+		t.Fatalf("Expected JSON log, but got %v", buf)
+	}
+
+	for name, value := range map[string]interface{}{"method": "GET", "path": "/users", "status": 201.0, "bytes": 5.0, "remote_ip": "192.0.2.1", "request_id": "abc", "level": "info"} {
+		if fields[name] != value {
+			t.Errorf("Expected field %v to be %v, but got %v", name, value, fields[name])
+		}
+	}
+
+	if _, ok := fields["latency"]; !ok {
+		t.Errorf("Expected latency to be logged, but got %v", fields)
+	}
+}
+
+func TestAccessLogCombined(t *testing.T) {
+	buf := serveAccessLog(AccessLogOptions{Format: AccessLogCombined}, "/users?page=2", http.StatusOK)
+	if !regexp.MustCompile(`^192\.0\.2\.1 - - \[.+\] "GET /users\?page=2 HTTP/1\.1" 200 5 "" "tester"\n$`).Match(buf.Bytes()) {
+		t.Errorf("Expected combined log line, but got %v", buf)
+	}
+}
+
+func TestAccessLogSkipAndSample(t *testing.T) {
+	for target, skipped := range map[string]bool{"/healthz": true, "/healthz/live": true, "/healthz-admin/delete": false, "/healthz/../admin/delete": false} {
+		if buf := serveAccessLog(AccessLogOptions{SkipPaths: []string{"/healthz"}}, target, http.StatusOK); (buf.Len() == 0) != skipped {
+			t.Errorf("Expected %v to be skipped %v, but got %v", target, skipped, buf)
+		}
+	}
+
+	if buf := serveAccessLog(AccessLogOptions{SampleRate: 0.000001}, "/", http.StatusOK); buf.Len() != 0 {
+		t.Errorf("Expected request to be sampled out, but got %v", buf)
+	}
+
+	buf := serveAccessLog(AccessLogOptions{SampleRate: 0.000001, Format: AccessLogJSON, Fields: []string{AccessLogStatus}}, "/", http.StatusBadGateway)
+	if !bytes.Contains(buf.Bytes(), []byte(`"level":"error"`)) || bytes.Contains(buf.Bytes(), []byte(`"path"`)) {
+		t.Errorf("Expected failed request to be logged as error with selected fields, but got %v", buf)
+	}
+}
+
+func TestAccessLogFactory(t *testing.T) {
+	factory, _ := DefaultRegistry.Lookup(AccessLogName)
+	for level, valid := range map[string]bool{"debug": true, "warning": true, "panic": false, "verbose": false} {
+		params, _ := factory.validate(map[string]interface{}{"level": level})
+		if _, err := factory.New(params); (err == nil) != valid {
+			t.Errorf("Expected level %v to be valid %v, but got %v", level, valid, err)
+		}
+	}
+}
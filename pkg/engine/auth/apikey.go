@@ -0,0 +1,148 @@
+package auth
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/bhojpur/middleware/pkg/engine"
+)
+
+// DefaultAPIKeyHeader header carrying API keys by default
+const DefaultAPIKeyHeader = "X-API-Key"
+
+// ErrUnknownAPIKey is returned by API key stores for unknown keys
+var ErrUnknownAPIKey = errors.New("unknown API key")
+
+// APIKeyStore looks up owners of API keys
+type APIKeyStore interface {
+	// Lookup returns the principal owning key, or ErrUnknownAPIKey
+	Lookup(ctx context.Context, key string) (*Principal, error)
+}
+
+// APIKeyStoreFunc function as APIKeyStore
+type APIKeyStoreFunc func(ctx context.Context, key string) (*Principal, error)
+
+// Lookup calls fn
+func (fn APIKeyStoreFunc) Lookup(ctx context.Context, key string) (*Principal, error) {
+	return fn(ctx, key)
+}
+
+// StaticAPIKeys APIKeyStore of API keys to their owners' subjects
+type StaticAPIKeys map[string]string
+
+// Lookup compares key with all keys in constant time
+func (keys StaticAPIKeys) Lookup(_ context.Context, key string) (*Principal, error) {
+	var (
+		digest  = sha256.Sum256([]byte(key))
+		subject string
+		found   int
+	)
+
+	for k, s := range keys {
+		d := sha256.Sum256([]byte(k))
+		if subtle.ConstantTimeCompare(digest[:], d[:]) == 1 {
+			subject, found = s, 1
+		}
+	}
+
+	if found == 0 {
+		return nil, ErrUnknownAPIKey
+	}
+	return &Principal{Subject: subject, Method: MethodAPIKey}, nil
+}
+
+// APIKeyAuthOptions options of APIKeyAuth
+type APIKeyAuthOptions struct {
+	// Header carrying API keys, DefaultAPIKeyHeader if it is blank
+	Header string
+	Store  APIKeyStore
+	// Optional passes requests without an API key through unauthenticated
+	Optional bool
+	// Logger is log.StandardLogger() if it is nil
+	Logger log.FieldLogger
+}
+
+// APIKeyAuth returns a middleware authenticating requests with API keys of a header
+func APIKeyAuth(options APIKeyAuthOptions) engine.Middleware {
+	if options.Header == "" {
+		options.Header = DefaultAPIKeyHeader
+	}
+
+	middleware := authMiddleware(engine.APIKeyAuthName, options.Optional, options.Logger, func(req *http.Request) (*Principal, error) {
+		key := req.Header.Get(options.Header)
+		if key == "" {
+			return nil, errNoCredentials
+		}
+
+		principal, err := options.Store.Lookup(req.Context(), key)
+		if err != nil {
+			return nil, err
+		}
+
+		if principal.Method == "" {
+			principal.Method = MethodAPIKey
+		}
+		return principal, nil
+	}, nil)
+
+	middleware.Validate = func() error {
+		if options.Store == nil {
+			return errors.New("no API key store")
+		}
+		return nil
+	}
+	return middleware
+}
+
+func init() {
+	engine.RegisterFactory(engine.APIKeyAuthName, engine.Factory{
+		Description: "authenticates requests with API keys of a header",
+		Params: []engine.Param{
+			{Name: "header", Type: engine.StringParam, Default: DefaultAPIKeyHeader},
+			{Name: "keys", Type: engine.StringListParam, Required: true, Description: "owners and their keys, like subject:key"},
+			{Name: "optional", Type: engine.BoolParam},
+		},
+		New: func(params engine.Params) (engine.Middleware, error) {
+			keys := StaticAPIKeys{}
+			for _, entry := range params.Strings("keys") {
+				idx := strings.Index(entry, ":")
+				if idx <= 0 || idx == len(entry)-1 {
+					return engine.Middleware{}, errors.New("invalid API key entry, should be like subject:key")
+				}
+				keys[entry[idx+1:]] = entry[:idx]
+			}
+
+			return APIKeyAuth(APIKeyAuthOptions{
+				Header:   params.String("header"),
+				Store:    keys,
+				Optional: params.Bool("optional"),
+			}), nil
+		},
+	})
+}
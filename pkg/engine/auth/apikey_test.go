@@ -0,0 +1,44 @@
+package auth
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPIKeyAuth(t *testing.T) {
+	handler := newTestHandler(t, APIKeyAuth(APIKeyAuthOptions{Header: "X-Token", Store: StaticAPIKeys{"k1": "svc1", "k2": "svc2"}}))
+
+	for key, expected := range map[string]string{"k1": "api_key:svc1", "k2": "api_key:svc2", "k3": "", "": ""} {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Token", key)
+		handler.ServeHTTP(recorder, req)
+
+		if expected == "" && recorder.Code != http.StatusUnauthorized {
+			t.Errorf("Expected key %q to be rejected, but got %v", key, recorder.Code)
+		} else if expected != "" && recorder.Body.String() != expected {
+			t.Errorf("Expected key %q to authenticate %v, but got %v %v", key, expected, recorder.Code, recorder.Body)
+		}
+	}
+}
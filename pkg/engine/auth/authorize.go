@@ -0,0 +1,382 @@
+package auth
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"sigs.k8s.io/yaml"
+
+	"github.com/bhojpur/middleware/pkg/engine"
+)
+
+// Effect effect of a policy rule
+type Effect string
+
+// Effects of policy rules
+const (
+	Allow Effect = "allow"
+	Deny  Effect = "deny"
+)
+
+// Policy authorization rules, it could be written in YAML or JSON, e.g.
+//
+//	default: deny
+//	rules:
+//	- name: admins
+//	  effect: allow
+//	  roles: [admin]
+//	- name: tenant members
+//	  effect: allow
+//	  methods: [GET]
+//	  paths: ["/tenants/{tenant}/**"]
+//	  attributes:
+//	    tenant: "{tenant}"
+//
+// The first rule matching a request decides, the default effect otherwise
+type Policy struct {
+	// Default effect if no rule matches, Deny if it is blank
+	Default Effect `json:"default,omitempty"`
+	Rules   []Rule `json:"rules"`
+}
+
+// Rule policy rule, it matches requests matching all of its conditions, blank conditions match any request
+type Rule struct {
+	Name   string `json:"name"`
+	Effect Effect `json:"effect"`
+	// Methods request methods
+	Methods []string `json:"methods,omitempty"`
+	// Paths path patterns, "*" matches a segment, "**" any number, even none, of
+	// trailing segments, and "{name}" a segment referenced by attributes. They
+	// are matched against the cleaned path, without a trailing slash
+	Paths []string `json:"paths,omitempty"`
+	// Roles matches principals having any of the roles
+	Roles []string `json:"roles,omitempty"`
+	// Subjects matches principals with any of the subjects
+	Subjects []string `json:"subjects,omitempty"`
+	// Attributes matches principals having all of the attributes, a value
+	// like "{name}" is the path segment captured by name
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// Decision authorization decision of a request
+type Decision struct {
+	Allowed bool
+	// Rule name of the deciding rule, blank if the default effect decided
+	Rule string
+}
+
+// ParsePolicy parse a YAML or JSON policy, unknown fields are rejected
+func ParsePolicy(data []byte) (*Policy, error) {
+	var policy Policy
+	if err := yaml.UnmarshalStrict(data, &policy); err != nil {
+		return nil, fmt.Errorf("invalid policy: %w", err)
+	}
+
+	if err := policy.validate(); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// LoadPolicy load a YAML or JSON policy from file path
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePolicy(data)
+}
+
+func (policy *Policy) validate() error {
+	if policy.Default != "" && policy.Default != Allow && policy.Default != Deny {
+		return fmt.Errorf("invalid default effect %q", policy.Default)
+	}
+
+	for idx, rule := range policy.Rules {
+		if rule.Effect != Allow && rule.Effect != Deny {
+			return fmt.Errorf("rule %v: invalid effect %q", rule.describe(idx), rule.Effect)
+		}
+
+		for _, pattern := range rule.Paths {
+			if !strings.HasPrefix(pattern, "/") {
+				return fmt.Errorf("rule %v: path %q should start with /", rule.describe(idx), pattern)
+			}
+
+			segments := strings.Split(pattern[1:], "/")
+			for i, segment := range segments {
+				if segment == "**" && i != len(segments)-1 {
+					return fmt.Errorf("rule %v: ** should be the last segment of %q", rule.describe(idx), pattern)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (rule Rule) describe(idx int) string {
+	if rule.Name != "" {
+		return rule.Name
+	}
+	return fmt.Sprint(idx)
+}
+
+// Evaluate decides if principal, nil for anonymous requests, is authorized to make req
+func (policy *Policy) Evaluate(principal *Principal, req *http.Request) Decision {
+	// "//admin" or "/public/../admin" must not escape rules of "/admin"
+	urlPath := path.Clean("/" + req.URL.Path)
+	for idx, rule := range policy.Rules {
+		if rule.matches(principal, req, urlPath) {
+			return Decision{Allowed: rule.Effect == Allow, Rule: rule.describe(idx)}
+		}
+	}
+	return Decision{Allowed: policy.Default == Allow}
+}
+
+func (rule Rule) matches(principal *Principal, req *http.Request, urlPath string) bool {
+	if len(rule.Methods) > 0 && !containsFold(rule.Methods, req.Method) {
+		return false
+	}
+
+	var params map[string]string
+	if len(rule.Paths) > 0 {
+		var matched bool
+		for _, pattern := range rule.Paths {
+			if params, matched = matchPath(pattern, urlPath); matched {
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if len(rule.Roles) == 0 && len(rule.Subjects) == 0 && len(rule.Attributes) == 0 {
+		return true
+	}
+
+	if principal == nil {
+		return false
+	}
+
+	if len(rule.Roles) > 0 {
+		var hasRole bool
+		for _, role := range rule.Roles {
+			hasRole = hasRole || principal.HasRole(role)
+		}
+		if !hasRole {
+			return false
+		}
+	}
+
+	if len(rule.Subjects) > 0 && !contains(rule.Subjects, principal.Subject) {
+		return false
+	}
+
+	for name, expected := range rule.Attributes {
+		if strings.HasPrefix(expected, "{") && strings.HasSuffix(expected, "}") {
+			param, ok := params[expected[1:len(expected)-1]]
+			if !ok {
+				return false
+			}
+			expected = param
+		}
+
+		if !contains(attributeValues(principal.Attributes[name]), expected) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchPath matches path with pattern, returns the segments captured by name
+func matchPath(pattern, path string) (map[string]string, bool) {
+	var (
+		params          = map[string]string{}
+		patternSegments = strings.Split(strings.TrimPrefix(pattern, "/"), "/")
+		pathSegments    = strings.Split(strings.TrimPrefix(path, "/"), "/")
+	)
+
+	for idx, segment := range patternSegments {
+		if segment == "**" {
+			return params, true
+		}
+
+		if idx >= len(pathSegments) {
+			return nil, false
+		}
+
+		switch {
+		case segment == "*":
+		case strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}"):
+			params[segment[1:len(segment)-1]] = pathSegments[idx]
+		case segment != pathSegments[idx]:
+			return nil, false
+		}
+	}
+	return params, len(patternSegments) == len(pathSegments)
+}
+
+// attributeValues returns values of an attribute as strings, a list attribute has several values
+func attributeValues(value interface{}) []string {
+	switch value := value.(type) {
+	case nil:
+		return nil
+	case []string:
+		return value
+	case []interface{}:
+		var values []string
+		for _, v := range value {
+			values = append(values, fmt.Sprint(v))
+		}
+		return values
+	}
+	return []string{fmt.Sprint(value)}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthorizeOptions options of Authorize
+type AuthorizeOptions struct {
+	Policy *Policy
+	// Authentication names of authentication middlewares setting the
+	// principal, Authorize requires them and runs after them
+	Authentication []string
+	// DryRun logs decisions without denying requests, for rolling out new policies
+	DryRun bool
+	// Logger of audit events, log.StandardLogger() if it is nil
+	Logger log.FieldLogger
+}
+
+// Authorize returns a middleware authorizing requests by the principal of
+// the authentication middlewares and options.Policy, denied requests get a 403
+// response. Every decision is logged as an audit event. Requests of paths
+// that aren't clean, like "//admin" or "/public/../admin", are redirected to
+// the cleaned path, so handlers never see a path other than the authorized one
+func Authorize(options AuthorizeOptions) engine.Middleware {
+	logger := options.Logger
+	if logger == nil {
+		logger = log.StandardLogger()
+	}
+
+	return engine.Middleware{
+		Name:        engine.AuthorizeName,
+		Requires:    options.Authentication,
+		InsertAfter: options.Authentication,
+		Validate: func() error {
+			if options.Policy == nil {
+				return errors.New("no policy")
+			}
+
+			if len(options.Authentication) == 0 {
+				return errors.New("no authentication middlewares")
+			}
+			return nil
+		},
+		Handler: func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if cleaned := engine.CleanPath(req.URL.Path); cleaned != req.URL.Path {
+					target := *req.URL
+					target.Path, target.RawPath = cleaned, ""
+					http.Redirect(w, req, target.RequestURI(), http.StatusPermanentRedirect)
+					return
+				}
+
+				principal := GetPrincipal(req)
+				decision := options.Policy.Evaluate(principal, req)
+
+				entry := logger.WithFields(log.Fields{
+					engine.AccessLogRequestID: engine.GetRequestID(req),
+					engine.AccessLogMethod:    req.Method,
+					engine.AccessLogPath:      req.URL.Path,
+					"subject":                 subjectOf(principal),
+					"rule":                    decision.Rule,
+					"allowed":                 decision.Allowed,
+					"dry_run":                 options.DryRun,
+				})
+
+				if decision.Allowed {
+					entry.Info("authorization allowed")
+				} else {
+					entry.Warn("authorization denied")
+				}
+
+				if !decision.Allowed && !options.DryRun {
+					engine.RenderError(w, req, http.StatusForbidden, http.StatusText(http.StatusForbidden))
+					return
+				}
+				next.ServeHTTP(w, req)
+			})
+		},
+	}
+}
+
+func subjectOf(principal *Principal) string {
+	if principal == nil {
+		return ""
+	}
+	return principal.Subject
+}
+
+func init() {
+	engine.RegisterFactory(engine.AuthorizeName, engine.Factory{
+		Description: "authorizes requests by a RBAC/ABAC policy",
+		Params: []engine.Param{
+			{Name: "policy_file", Type: engine.StringParam, Required: true},
+			{Name: "authentication", Type: engine.StringListParam, Required: true, Description: "names of the authentication middlewares"},
+			{Name: "dry_run", Type: engine.BoolParam},
+		},
+		New: func(params engine.Params) (engine.Middleware, error) {
+			policy, err := LoadPolicy(params.String("policy_file"))
+			if err != nil {
+				return engine.Middleware{}, err
+			}
+
+			return Authorize(AuthorizeOptions{
+				Policy:         policy,
+				Authentication: params.Strings("authentication"),
+				DryRun:         params.Bool("dry_run"),
+			}), nil
+		},
+	})
+}
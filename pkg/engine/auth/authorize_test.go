@@ -0,0 +1,183 @@
+package auth
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/bhojpur/middleware/pkg/engine"
+)
+
+const testPolicy = `
+default: deny
+rules:
+- name: blocked
+  effect: deny
+  subjects: [mallory]
+- name: admins
+  effect: allow
+  roles: [admin]
+- name: tenant readers
+  effect: allow
+  methods: [GET]
+  paths: ["/tenants/{tenant}/**"]
+  attributes:
+    tenant: "{tenant}"
+- name: health
+  effect: allow
+  paths: [/healthz]
+`
+
+func TestAuthorize(t *testing.T) {
+	policy, err := ParsePolicy([]byte(testPolicy))
+	if err != nil {
+		t.Fatalf("Failed to parse policy, got %v", err)
+	}
+
+	var (
+		buf    = &bytes.Buffer{}
+		logger = log.New()
+	)
+	logger.Out = buf
+
+	principals := APIKeyStoreFunc(func(_ context.Context, key string) (*Principal, error) {
+		switch key {
+		case "admin":
+			return &Principal{Subject: "root", Roles: []string{"admin"}}, nil
+		case "mallory":
+			return &Principal{Subject: "mallory", Roles: []string{"admin"}}, nil
+		case "t1":
+			return &Principal{Subject: "alice", Attributes: map[string]interface{}{"tenant": []interface{}{"t1", "t3"}}}, nil
+		}
+		return nil, ErrUnknownAPIKey
+	})
+
+	handler := newTestHandler(t,
+		APIKeyAuth(APIKeyAuthOptions{Store: principals, Optional: true}),
+		Authorize(AuthorizeOptions{Policy: policy, Authentication: []string{engine.APIKeyAuthName}, Logger: logger}),
+	)
+
+	for _, c := range []struct {
+		key, method, path string
+		status            int
+	}{
+		{"admin", "DELETE", "/anything", http.StatusOK},
+		{"mallory", "GET", "/anything", http.StatusForbidden},
+		{"t1", "GET", "/tenants/t1/users/1", http.StatusOK},
+		{"t1", "GET", "/tenants/t3", http.StatusOK},
+		{"t1", "GET", "/tenants", http.StatusForbidden},
+		{"t1", "GET", "/tenants/t2/users", http.StatusForbidden},
+		{"t1", "POST", "/tenants/t1/users", http.StatusForbidden},
+		{"", "GET", "/healthz", http.StatusOK},
+		{"", "GET", "/tenants/t1/users", http.StatusForbidden},
+		{"", "GET", "/healthz/", http.StatusOK},
+		{"", "GET", "//tenants/t1/users", http.StatusPermanentRedirect},
+		{"", "GET", "/healthz/../tenants/t1/users", http.StatusPermanentRedirect},
+		{"t1", "GET", "/tenants/t1/./users", http.StatusPermanentRedirect},
+	} {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(c.method, c.path, nil)
+		if c.key != "" {
+			req.Header.Set(DefaultAPIKeyHeader, c.key)
+		}
+		handler.ServeHTTP(recorder, req)
+
+		if recorder.Code != c.status {
+			t.Errorf("Expected %v %v by %v to get %v, but got %v", c.method, c.path, c.key, c.status, recorder.Code)
+		}
+	}
+
+	for target, location := range map[string]string{"//tenants/t1/users?page=2": "/tenants/t1/users?page=2", "/healthz/../tenants/t1/": "/tenants/t1/"} {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", target, nil))
+		if recorder.Code != http.StatusPermanentRedirect || recorder.Header().Get("Location") != location {
+			t.Errorf("Expected %v to be redirected to %v, but got %v %v", target, location, recorder.Code, recorder.Header().Get("Location"))
+		}
+	}
+
+	// Evaluate matches rules against the cleaned path, even without the middleware's redirect
+	for _, target := range []string{"//tenants/t2/users", "/healthz/../tenants/t2/users", "/healthz/.."} {
+		if decision := policy.Evaluate(nil, httptest.NewRequest("GET", target, nil)); decision.Allowed {
+			t.Errorf("Expected %v not to be allowed by rule %v", target, decision.Rule)
+		}
+	}
+
+	if !strings.Contains(buf.String(), "authorization denied") || !strings.Contains(buf.String(), "rule=blocked") || !strings.Contains(buf.String(), "subject=mallory") {
+		t.Errorf("Expected audit events, but got %v", buf)
+	}
+}
+
+func TestAuthorizeDryRun(t *testing.T) {
+	policy, _ := ParsePolicy([]byte("rules: []"))
+
+	var (
+		buf    = &bytes.Buffer{}
+		logger = log.New()
+	)
+	logger.Out = buf
+
+	handler := newTestHandler(t,
+		APIKeyAuth(APIKeyAuthOptions{Store: StaticAPIKeys{}, Optional: true}),
+		Authorize(AuthorizeOptions{Policy: policy, Authentication: []string{engine.APIKeyAuthName}, DryRun: true, Logger: logger}),
+	)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+
+	if recorder.Code != http.StatusOK || !strings.Contains(buf.String(), "dry_run=true") {
+		t.Errorf("Expected dry run to log denial without denying, but got %v %v", recorder.Code, buf)
+	}
+}
+
+func TestAuthorizeRequiresAuthentication(t *testing.T) {
+	policy, _ := ParsePolicy([]byte("default: allow"))
+
+	stack := &engine.MiddlewareStack{}
+	stack.Use(engine.RequestID(engine.RequestIDOptions{}))
+	stack.Use(Authorize(AuthorizeOptions{Policy: policy, Authentication: []string{engine.BearerAuthName}}))
+
+	var missingErr *engine.MissingRequirementError
+	if _, err := stack.Compile(http.NotFoundHandler()); !errors.As(err, &missingErr) {
+		t.Errorf("Expected *MissingRequirementError, but got %v", err)
+	}
+}
+
+func TestParseInvalidPolicy(t *testing.T) {
+	for _, policy := range []string{
+		"default: maybe",
+		"rules: [{effect: permit}]",
+		"rules: [{effect: allow, paths: [users]}]",
+		"rules: [{effect: allow, paths: [/a/**/b]}]",
+		"rules: [{effect: allow, unknown: true}]",
+	} {
+		if _, err := ParsePolicy([]byte(policy)); err == nil {
+			t.Errorf("Expected policy %q to be invalid", policy)
+		}
+	}
+}
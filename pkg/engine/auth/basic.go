@@ -0,0 +1,143 @@
+package auth
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/bhojpur/middleware/pkg/engine"
+)
+
+// ErrInvalidCredentials is returned by credential stores for unknown users or wrong passwords
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// CredentialStore checks user names and passwords
+type CredentialStore interface {
+	// Authenticate returns the principal of username, or ErrInvalidCredentials
+	Authenticate(ctx context.Context, username, password string) (*Principal, error)
+}
+
+// CredentialStoreFunc function as CredentialStore
+type CredentialStoreFunc func(ctx context.Context, username, password string) (*Principal, error)
+
+// Authenticate calls fn
+func (fn CredentialStoreFunc) Authenticate(ctx context.Context, username, password string) (*Principal, error) {
+	return fn(ctx, username, password)
+}
+
+// BcryptCredentials CredentialStore of user names to bcrypt password hashes
+type BcryptCredentials map[string]string
+
+// dummyHash is compared for unknown users, so they take as long as known ones
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+// Authenticate compares password with the hash of username
+func (credentials BcryptCredentials) Authenticate(_ context.Context, username, password string) (*Principal, error) {
+	hash, ok := credentials[username]
+	if !ok {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return &Principal{Subject: username, Method: MethodBasic}, nil
+}
+
+// BasicAuthOptions options of BasicAuth
+type BasicAuthOptions struct {
+	// Realm sent in the WWW-Authenticate challenge, "Restricted" if it is blank
+	Realm string
+	Store CredentialStore
+	// Optional passes requests without credentials through unauthenticated
+	Optional bool
+	// Logger is log.StandardLogger() if it is nil
+	Logger log.FieldLogger
+}
+
+// BasicAuth returns a middleware authenticating requests with HTTP Basic credentials checked by options.Store
+func BasicAuth(options BasicAuthOptions) engine.Middleware {
+	if options.Realm == "" {
+		options.Realm = "Restricted"
+	}
+
+	middleware := authMiddleware(engine.BasicAuthName, options.Optional, options.Logger, func(req *http.Request) (*Principal, error) {
+		username, password, ok := req.BasicAuth()
+		if !ok {
+			return nil, errNoCredentials
+		}
+
+		principal, err := options.Store.Authenticate(req.Context(), username, password)
+		if err != nil {
+			return nil, fmt.Errorf("user %v: %w", username, err)
+		}
+
+		if principal.Method == "" {
+			principal.Method = MethodBasic
+		}
+		return principal, nil
+	}, func(w http.ResponseWriter, err error) {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm=%q, charset="UTF-8"`, options.Realm))
+	})
+
+	middleware.Validate = func() error {
+		if options.Store == nil {
+			return errors.New("no credential store")
+		}
+		return nil
+	}
+	return middleware
+}
+
+func init() {
+	engine.RegisterFactory(engine.BasicAuthName, engine.Factory{
+		Description: "authenticates requests with HTTP Basic credentials",
+		Params: []engine.Param{
+			{Name: "realm", Type: engine.StringParam},
+			{Name: "users", Type: engine.StringListParam, Required: true, Description: "user names and bcrypt hashes, like name:hash"},
+			{Name: "optional", Type: engine.BoolParam},
+		},
+		New: func(params engine.Params) (engine.Middleware, error) {
+			credentials := BcryptCredentials{}
+			for _, user := range params.Strings("users") {
+				idx := strings.Index(user, ":")
+				if idx <= 0 {
+					return engine.Middleware{}, fmt.Errorf("invalid user %q, should be like name:hash", user)
+				}
+				credentials[user[:idx]] = user[idx+1:]
+			}
+
+			return BasicAuth(BasicAuthOptions{
+				Realm:    params.String("realm"),
+				Store:    credentials,
+				Optional: params.Bool("optional"),
+			}), nil
+		},
+	})
+}
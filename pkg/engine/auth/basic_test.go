@@ -0,0 +1,108 @@
+package auth
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/bhojpur/middleware/pkg/engine"
+)
+
+// newTestHandler returns middlewares applied with RequestID to a handler echoing the principal's subject
+func newTestHandler(t *testing.T, middlewares ...engine.Middleware) http.Handler {
+	stack := &engine.MiddlewareStack{}
+	stack.Use(engine.RequestID(engine.RequestIDOptions{}))
+	for _, middleware := range middlewares {
+		stack.Use(middleware)
+	}
+
+	handler, err := stack.Compile(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if principal := GetPrincipal(req); principal != nil {
+			w.Write([]byte(principal.Method + ":" + principal.Subject))
+		}
+	}))
+	if err != nil {
+		t.Fatalf("Failed to compile stack, got %v", err)
+	}
+	return handler
+}
+
+func TestBasicAuth(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	handler := newTestHandler(t, BasicAuth(BasicAuthOptions{Realm: "test", Store: BcryptCredentials{"alice": string(hash)}}))
+
+	for _, c := range []struct {
+		username, password string
+		status             int
+		body               string
+	}{
+		{"alice", "secret", http.StatusOK, "basic:alice"},
+		{"alice", "wrong", http.StatusUnauthorized, ""},
+		{"bob", "secret", http.StatusUnauthorized, ""},
+		{"", "", http.StatusUnauthorized, ""},
+	} {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/", nil)
+		if c.username != "" {
+			req.SetBasicAuth(c.username, c.password)
+		}
+		handler.ServeHTTP(recorder, req)
+
+		if recorder.Code != c.status || (c.body != "" && recorder.Body.String() != c.body) {
+			t.Errorf("Expected %v %v for %v, but got %v %v", c.status, c.body, c.username, recorder.Code, recorder.Body)
+		}
+
+		if c.status == http.StatusUnauthorized && recorder.Header().Get("WWW-Authenticate") != `Basic realm="test", charset="UTF-8"` {
+			t.Errorf("Expected Basic challenge, but got %v", recorder.Header())
+		}
+	}
+}
+
+func TestAuthRequiresRequestID(t *testing.T) {
+	stack := &engine.MiddlewareStack{}
+	stack.Use(BasicAuth(BasicAuthOptions{Store: BcryptCredentials{}}))
+
+	var missingErr *engine.MissingRequirementError
+	if _, err := stack.Compile(http.NotFoundHandler()); !errors.As(err, &missingErr) {
+		t.Errorf("Expected *MissingRequirementError, but got %v", err)
+	}
+}
+
+func TestOptionalAuth(t *testing.T) {
+	handler := newTestHandler(t,
+		BasicAuth(BasicAuthOptions{Store: BcryptCredentials{}, Optional: true}),
+		APIKeyAuth(APIKeyAuthOptions{Store: StaticAPIKeys{"key": "service"}}),
+	)
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(DefaultAPIKeyHeader, "key")
+	handler.ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "api_key:service" {
+		t.Errorf("Expected request without basic credentials to be authenticated by API key, but got %v %v", recorder.Code, recorder.Body)
+	}
+}
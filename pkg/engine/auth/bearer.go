@@ -0,0 +1,264 @@
+package auth
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/bhojpur/middleware/pkg/engine"
+)
+
+// Algorithms verified by BearerAuth
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+)
+
+// minSecretLength minimum length of HS256 secrets, as long as the hash
+const minSecretLength = 32
+
+// BearerAuthOptions options of BearerAuth
+type BearerAuthOptions struct {
+	// Realm sent in the WWW-Authenticate challenge, "Restricted" if it is blank
+	Realm string
+	// Secret verifies HS256 tokens, it should be at least 32 bytes
+	Secret []byte
+	// Keys verifies RS256 and ES256 tokens
+	Keys *KeySet
+	// Algorithms accepted, HS256 if Secret is set and RS256, ES256 if Keys is set, by default
+	Algorithms []string
+	// Issuer and Audience are checked if they are not blank
+	Issuer   string
+	Audience string
+	// Leeway tolerated clock skew checking exp and nbf claims
+	Leeway time.Duration
+	// RolesClaim claim of Principal.Roles, "roles" if it is blank
+	RolesClaim string
+	// Optional passes requests without a bearer token through unauthenticated
+	Optional bool
+	// Logger is log.StandardLogger() if it is nil
+	Logger log.FieldLogger
+}
+
+// BearerAuth returns a middleware authenticating requests with JWT bearer
+// tokens, they must be signed with one of the algorithms, have an exp claim
+// and match the issuer and audience. The principal's attributes are the token's claims
+func BearerAuth(options BearerAuthOptions) engine.Middleware {
+	if options.Realm == "" {
+		options.Realm = "Restricted"
+	}
+
+	if len(options.Algorithms) == 0 {
+		if len(options.Secret) > 0 {
+			options.Algorithms = append(options.Algorithms, HS256)
+		}
+		if options.Keys != nil {
+			options.Algorithms = append(options.Algorithms, RS256, ES256)
+		}
+	}
+
+	if options.RolesClaim == "" {
+		options.RolesClaim = "roles"
+	}
+
+	parser := jwt.NewParser(jwt.WithValidMethods(options.Algorithms), jwt.WithoutClaimsValidation())
+
+	middleware := authMiddleware(engine.BearerAuthName, options.Optional, options.Logger, func(req *http.Request) (*Principal, error) {
+		authorization := req.Header.Get("Authorization")
+		if len(authorization) < 7 || !strings.EqualFold(authorization[:7], "Bearer ") {
+			return nil, errNoCredentials
+		}
+
+		claims := jwt.MapClaims{}
+		_, err := parser.ParseWithClaims(strings.TrimSpace(authorization[7:]), claims, func(token *jwt.Token) (interface{}, error) {
+			alg := token.Method.Alg()
+			if alg == HS256 && len(options.Secret) > 0 {
+				return options.Secret, nil
+			}
+
+			if options.Keys == nil {
+				return nil, fmt.Errorf("no key for %v", alg)
+			}
+
+			kid, _ := token.Header["kid"].(string)
+			return options.Keys.Key(req.Context(), kid, alg)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if err := options.verifyClaims(claims, time.Now()); err != nil {
+			return nil, err
+		}
+
+		subject, _ := claims["sub"].(string)
+		return &Principal{Subject: subject, Method: MethodBearer, Roles: stringsClaim(claims[options.RolesClaim]), Attributes: claims}, nil
+	}, func(w http.ResponseWriter, err error) {
+		if err == errNoCredentials {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm=%q`, options.Realm))
+		} else {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm=%q, error="invalid_token"`, options.Realm))
+		}
+	})
+
+	middleware.Validate = func() error {
+		if len(options.Algorithms) == 0 {
+			return errors.New("no secret or key set")
+		}
+
+		for _, alg := range options.Algorithms {
+			switch alg {
+			case HS256:
+				if len(options.Secret) > 0 && len(options.Secret) < minSecretLength {
+					return fmt.Errorf("secret should be at least %v bytes", minSecretLength)
+				}
+				if len(options.Secret) == 0 && options.Keys == nil {
+					return errors.New("HS256 needs a secret or key set")
+				}
+			case RS256, ES256:
+				if options.Keys == nil {
+					return fmt.Errorf("%v needs a key set", alg)
+				}
+			default:
+				return fmt.Errorf("unsupported algorithm %v", alg)
+			}
+		}
+
+		if options.Keys != nil && options.Keys.File == "" && options.Keys.URL == "" {
+			return errors.New("key set has no file or URL")
+		}
+
+		if options.Leeway < 0 {
+			return fmt.Errorf("leeway %v should be positive", options.Leeway)
+		}
+		return nil
+	}
+	return middleware
+}
+
+// verifyClaims checks the registered claims of a token at now
+func (options BearerAuthOptions) verifyClaims(claims jwt.MapClaims, now time.Time) error {
+	expiresAt, ok := timeClaim(claims["exp"])
+	if !ok {
+		return errors.New("token has no valid exp claim")
+	}
+
+	if now.After(expiresAt.Add(options.Leeway)) {
+		return fmt.Errorf("token expired at %v", expiresAt)
+	}
+
+	if value, exists := claims["nbf"]; exists {
+		notBefore, ok := timeClaim(value)
+		if !ok {
+			return errors.New("token has an invalid nbf claim")
+		}
+		if now.Add(options.Leeway).Before(notBefore) {
+			return fmt.Errorf("token is not valid before %v", notBefore)
+		}
+	}
+
+	if options.Issuer != "" {
+		if issuer, _ := claims["iss"].(string); issuer != options.Issuer {
+			return fmt.Errorf("token issuer %q is not %q", issuer, options.Issuer)
+		}
+	}
+
+	if options.Audience != "" {
+		var found bool
+		for _, audience := range stringsClaim(claims["aud"]) {
+			found = found || audience == options.Audience
+		}
+		if !found {
+			return fmt.Errorf("token audience is not %q", options.Audience)
+		}
+	}
+	return nil
+}
+
+// timeClaim returns the time of a NumericDate claim
+func timeClaim(value interface{}) (time.Time, bool) {
+	seconds, ok := value.(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(seconds*float64(time.Second))), true
+}
+
+// stringsClaim returns values of a claim holding a string list, or a space separated string
+func stringsClaim(value interface{}) []string {
+	switch value := value.(type) {
+	case string:
+		return strings.Fields(value)
+	case []interface{}:
+		var values []string
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func init() {
+	engine.RegisterFactory(engine.BearerAuthName, engine.Factory{
+		Description: "authenticates requests with JWT bearer tokens",
+		Params: []engine.Param{
+			{Name: "realm", Type: engine.StringParam},
+			{Name: "secret", Type: engine.StringParam, Description: "verifies HS256 tokens"},
+			{Name: "jwks_file", Type: engine.StringParam},
+			{Name: "jwks_url", Type: engine.StringParam},
+			{Name: "jwks_refresh", Type: engine.DurationParam},
+			{Name: "algorithms", Type: engine.StringListParam},
+			{Name: "issuer", Type: engine.StringParam},
+			{Name: "audience", Type: engine.StringParam},
+			{Name: "leeway", Type: engine.DurationParam},
+			{Name: "roles_claim", Type: engine.StringParam},
+			{Name: "optional", Type: engine.BoolParam},
+		},
+		New: func(params engine.Params) (engine.Middleware, error) {
+			options := BearerAuthOptions{
+				Realm:      params.String("realm"),
+				Secret:     []byte(params.String("secret")),
+				Algorithms: params.Strings("algorithms"),
+				Issuer:     params.String("issuer"),
+				Audience:   params.String("audience"),
+				Leeway:     params.Duration("leeway"),
+				RolesClaim: params.String("roles_claim"),
+				Optional:   params.Bool("optional"),
+			}
+
+			if file, url := params.String("jwks_file"), params.String("jwks_url"); file != "" || url != "" {
+				options.Keys = &KeySet{File: file, URL: url, Refresh: params.Duration("jwks_refresh")}
+			}
+			return BearerAuth(options), nil
+		},
+	})
+}
@@ -0,0 +1,170 @@
+package auth
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+
+	"github.com/bhojpur/middleware/pkg/engine"
+)
+
+func encodeInt(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+func TestBearerAuth(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	jwks, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "use": "sig", "n": encodeInt(rsaKey.N), "e": encodeInt(big.NewInt(int64(rsaKey.E)))},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": encodeInt(ecKey.X), "y": encodeInt(ecKey.Y)},
+	}})
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	os.WriteFile(file, jwks, 0600)
+
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	handler := newTestHandler(t, BearerAuth(BearerAuthOptions{
+		Secret:     secret,
+		Keys:       &KeySet{File: file},
+		Algorithms: []string{HS256, RS256, ES256},
+		Issuer:     "https://issuer.example.com",
+		Audience:   "api",
+		Leeway:     time.Minute,
+	}))
+
+	sign := func(method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(method, claims)
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatalf("Failed to sign token, got %v", err)
+		}
+		return signed
+	}
+
+	claims := func(overrides jwt.MapClaims) jwt.MapClaims {
+		claims := jwt.MapClaims{"sub": "alice", "iss": "https://issuer.example.com", "aud": []string{"api", "other"}, "exp": time.Now().Add(time.Hour).Unix(), "roles": "admin dev"}
+		for name, value := range overrides {
+			if value == nil {
+				delete(claims, name)
+			} else {
+				claims[name] = value
+			}
+		}
+		return claims
+	}
+
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	for name, c := range map[string]struct {
+		token string
+		valid bool
+	}{
+		"HS256":           {sign(jwt.SigningMethodHS256, "", secret, claims(nil)), true},
+		"RS256":           {sign(jwt.SigningMethodRS256, "rsa", rsaKey, claims(nil)), true},
+		"ES256":           {sign(jwt.SigningMethodES256, "ec", ecKey, claims(nil)), true},
+		"leeway":          {sign(jwt.SigningMethodHS256, "", secret, claims(jwt.MapClaims{"exp": time.Now().Add(-30 * time.Second).Unix()})), true},
+		"expired":         {sign(jwt.SigningMethodHS256, "", secret, claims(jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()})), false},
+		"no expiry":       {sign(jwt.SigningMethodHS256, "", secret, claims(jwt.MapClaims{"exp": nil})), false},
+		"not yet valid":   {sign(jwt.SigningMethodHS256, "", secret, claims(jwt.MapClaims{"nbf": time.Now().Add(time.Hour).Unix()})), false},
+		"wrong issuer":    {sign(jwt.SigningMethodHS256, "", secret, claims(jwt.MapClaims{"iss": "https://evil.com"})), false},
+		"wrong audience":  {sign(jwt.SigningMethodHS256, "", secret, claims(jwt.MapClaims{"aud": "other"})), false},
+		"wrong key":       {sign(jwt.SigningMethodRS256, "rsa", otherKey, claims(nil)), false},
+		"unknown kid":     {sign(jwt.SigningMethodRS256, "unknown", rsaKey, claims(nil)), false},
+		"wrong secret":    {sign(jwt.SigningMethodHS256, "", []byte("fedcba9876543210fedcba9876543210"), claims(nil)), false},
+		"unsupported alg": {sign(jwt.SigningMethodHS512, "", secret, claims(nil)), false},
+		"malformed":       {"not.a.token", false},
+	} {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+c.token)
+		handler.ServeHTTP(recorder, req)
+
+		if c.valid && (recorder.Code != http.StatusOK || recorder.Body.String() != "bearer:alice") {
+			t.Errorf("Expected %v token to be accepted, but got %v %v", name, recorder.Code, recorder.Body)
+		}
+
+		if !c.valid && (recorder.Code != http.StatusUnauthorized || recorder.Header().Get("WWW-Authenticate") != `Bearer realm="Restricted", error="invalid_token"`) {
+			t.Errorf("Expected %v token to be rejected, but got %v %v", name, recorder.Code, recorder.Header())
+		}
+	}
+}
+
+func TestBearerAuthPrincipal(t *testing.T) {
+	var (
+		secret    = []byte("0123456789abcdef0123456789abcdef")
+		principal *Principal
+		stack     = &engine.MiddlewareStack{}
+	)
+
+	stack.Use(engine.RequestID(engine.RequestIDOptions{}))
+	stack.Use(BearerAuth(BearerAuthOptions{Secret: secret}))
+	handler := stack.MustApply(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		principal = GetPrincipal(req)
+	}))
+
+	token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "bob", "exp": time.Now().Add(time.Hour).Unix(), "roles": []string{"admin"}, "tenant": "t1"}).SignedString(secret)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "bearer "+token)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if principal == nil || principal.Subject != "bob" || !principal.HasRole("admin") || principal.Attributes["tenant"] != "t1" {
+		t.Errorf("Expected principal of bob with claims, but got %+v", principal)
+	}
+}
+
+func TestBearerAuthInvalidOptions(t *testing.T) {
+	for _, options := range []BearerAuthOptions{
+		{},
+		{Secret: []byte("short")},
+		{Secret: []byte("0123456789abcdef0123456789abcdef"), Algorithms: []string{RS256}},
+		{Keys: &KeySet{}},
+		{Keys: &KeySet{URL: "https://example.com/jwks.json"}, Algorithms: []string{"none"}},
+	} {
+		stack := &engine.MiddlewareStack{}
+		stack.Use(engine.RequestID(engine.RequestIDOptions{}))
+		stack.Use(BearerAuth(options))
+
+		var invalidErr *engine.InvalidMiddlewareError
+		if _, err := stack.Compile(http.NotFoundHandler()); !errors.As(err, &invalidErr) {
+			t.Errorf("Expected %+v to be invalid, but got %v", options, err)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package auth
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultKeySetRefresh how often a KeySet is reloaded by default
+const DefaultKeySetRefresh = time.Hour
+
+// KeySetTimeout bounds reading or fetching a KeySet
+const KeySetTimeout = 10 * time.Second
+
+// minKeySetRefresh minimum interval of reloading a KeySet, e.g. for unknown key IDs
+const minKeySetRefresh = time.Minute
+
+// KeySet JSON Web Key Set verifying tokens, loaded from File or URL on first use,
+// it is reloaded every Refresh, or sooner when a token has an unknown key ID.
+// Loaded keys are served while it is reloaded, concurrent reloads are done once
+type KeySet struct {
+	File string
+	URL  string
+	// Client fetches URL, http.DefaultClient if it is nil, requests time out after KeySetTimeout
+	Client *http.Client
+	// Refresh is DefaultKeySetRefresh if it is zero
+	Refresh time.Duration
+
+	mu        sync.Mutex
+	keys      []jsonWebKey
+	loaded    time.Time
+	attempted time.Time
+	loading   chan struct{}
+	loadErr   error
+}
+
+type jsonWebKey struct {
+	id        string
+	algorithm string
+	key       interface{}
+}
+
+// Key returns the public key, or the secret as []byte, of key ID kid for algorithm alg
+func (set *KeySet) Key(ctx context.Context, kid, alg string) (interface{}, error) {
+	refresh := set.Refresh
+	if refresh == 0 {
+		refresh = DefaultKeySetRefresh
+	}
+
+	set.mu.Lock()
+	key, stale := set.find(kid, alg), time.Since(set.loaded) > refresh
+	set.mu.Unlock()
+
+	if key != nil {
+		if stale {
+			// the loaded key is served while the key set is reloaded in the background
+			set.reload()
+		}
+		return key, nil
+	}
+
+	// the key ID is unknown, or no keys are loaded yet
+	select {
+	case <-set.reload():
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	if key := set.find(kid, alg); key != nil {
+		return key, nil
+	}
+
+	if set.loadErr != nil {
+		return nil, set.loadErr
+	}
+	return nil, fmt.Errorf("no %v key %q in key set", alg, kid)
+}
+
+func (set *KeySet) find(kid, alg string) interface{} {
+	for _, key := range set.keys {
+		if (kid == "" || key.id == kid) && (key.algorithm == "" || key.algorithm == alg) && keyMatches(key.key, alg) {
+			return key.key
+		}
+	}
+	return nil
+}
+
+// keyMatches returns true if key could verify algorithm alg
+func keyMatches(key interface{}, alg string) bool {
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return alg == "RS256"
+	case *ecdsa.PublicKey:
+		return alg == "ES256" && key.Curve == elliptic.P256()
+	case []byte:
+		return alg == "HS256"
+	}
+	return false
+}
+
+// reload starts reloading the key set, at most once per minKeySetRefresh,
+// it returns a channel closed when the running reload is done. Keys already
+// loaded are kept when reloading fails
+func (set *KeySet) reload() <-chan struct{} {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	if set.loading != nil {
+		return set.loading
+	}
+
+	done := make(chan struct{})
+	if time.Since(set.attempted) < minKeySetRefresh {
+		close(done)
+		return done
+	}
+
+	set.attempted = time.Now()
+	set.loading = done
+
+	go func() {
+		defer close(done)
+
+		// the reload is shared by all waiting requests, so it isn't canceled with any of them
+		ctx, cancel := context.WithTimeout(context.Background(), KeySetTimeout)
+		defer cancel()
+		keys, err := set.load(ctx)
+
+		set.mu.Lock()
+		defer set.mu.Unlock()
+
+		if err == nil {
+			set.keys, set.loaded = keys, time.Now()
+		}
+		set.loading, set.loadErr = nil, err
+	}()
+	return done
+}
+
+// load reads and parses the key set
+func (set *KeySet) load(ctx context.Context) ([]jsonWebKey, error) {
+	var (
+		data []byte
+		err  error
+	)
+
+	if set.File != "" {
+		data, err = os.ReadFile(set.File)
+	} else {
+		data, err = set.fetch(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseKeySet(data)
+}
+
+func (set *KeySet) fetch(ctx context.Context) ([]byte, error) {
+	if set.URL == "" {
+		return nil, errors.New("key set has no file or URL")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, set.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	client := set.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch key set %v: %v", set.URL, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// parseKeySet parses signing keys of a JSON Web Key Set, other keys are skipped
+func parseKeySet(data []byte) ([]jsonWebKey, error) {
+	var set struct {
+		Keys []struct {
+			Type      string `json:"kty"`
+			ID        string `json:"kid"`
+			Algorithm string `json:"alg"`
+			Use       string `json:"use"`
+			N         string `json:"n"`
+			E         string `json:"e"`
+			Curve     string `json:"crv"`
+			X         string `json:"x"`
+			Y         string `json:"y"`
+			K         string `json:"k"`
+		} `json:"keys"`
+	}
+
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid key set: %w", err)
+	}
+
+	var keys []jsonWebKey
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		var key interface{}
+		switch k.Type {
+		case "RSA":
+			n, e := decodeInt(k.N), decodeInt(k.E)
+			if n == nil || e == nil || !e.IsInt64() {
+				return nil, fmt.Errorf("invalid RSA key %q", k.ID)
+			}
+			key = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		case "EC":
+			x, y := decodeInt(k.X), decodeInt(k.Y)
+			if k.Curve != "P-256" || x == nil || y == nil || !elliptic.P256().IsOnCurve(x, y) {
+				return nil, fmt.Errorf("invalid EC key %q", k.ID)
+			}
+			key = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		case "oct":
+			secret, err := base64.RawURLEncoding.DecodeString(k.K)
+			if err != nil || len(secret) == 0 {
+				return nil, fmt.Errorf("invalid secret key %q", k.ID)
+			}
+			key = secret
+		default:
+			continue
+		}
+
+		keys = append(keys, jsonWebKey{id: k.ID, algorithm: k.Algorithm, key: key})
+	}
+	return keys, nil
+}
+
+// decodeInt decodes a base64url encoded big-endian integer, returns nil if it is invalid
+func decodeInt(s string) *big.Int {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(data) == 0 {
+		return nil
+	}
+	return new(big.Int).SetBytes(data)
+}
@@ -0,0 +1,82 @@
+package auth
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestKeySetReload(t *testing.T) {
+	var (
+		fetches int32
+		blocked = make(chan struct{})
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&fetches, 1) > 1 {
+			<-blocked
+		}
+		w.Write([]byte(`{"keys": [{"kty": "oct", "kid": "hmac", "k": "c2VjcmV0"}]}`))
+	}))
+	defer server.Close()
+
+	set := &KeySet{URL: server.URL}
+	if key, err := set.Key(context.Background(), "hmac", HS256); err != nil || string(key.([]byte)) != "secret" {
+		t.Fatalf("Expected key to be loaded, but got %v %v", key, err)
+	}
+
+	// the key set is stale, a reload hangs
+	set.mu.Lock()
+	set.Refresh, set.attempted = time.Nanosecond, time.Time{}
+	set.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := set.Key(context.Background(), "hmac", HS256); err != nil {
+				t.Errorf("Expected loaded key to be served while reloading, but got %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := set.Key(ctx, "unknown", HS256); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected waiting for unknown key to end with the request, but got %v", err)
+	}
+
+	done := set.reload()
+	close(blocked)
+	<-done
+
+	if count := atomic.LoadInt32(&fetches); count != 2 {
+		t.Errorf("Expected concurrent reloads to fetch the key set once, but got %v fetches", count)
+	}
+}
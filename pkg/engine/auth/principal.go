@@ -0,0 +1,130 @@
+package auth
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/bhojpur/middleware/pkg/engine"
+)
+
+// Authentication methods of Principal
+const (
+	MethodBasic  = "basic"
+	MethodBearer = "bearer"
+	MethodAPIKey = "api_key"
+)
+
+// Principal authenticated caller of a request, shared by all authentication middlewares
+type Principal struct {
+	// Subject user name, token subject or API key owner
+	Subject string
+	// Method authentication method, e.g. MethodBearer
+	Method string
+	Roles  []string
+	// Attributes extra attributes of the caller, e.g. the claims of a token
+	Attributes map[string]interface{}
+}
+
+// HasRole returns true if the principal has role
+func (principal *Principal) HasRole(role string) bool {
+	for _, r := range principal.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+type principalKey struct{}
+
+// ContextWithPrincipal returns a copy of ctx carrying principal
+func ContextWithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext returns the principal carried by ctx, or nil
+func PrincipalFromContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalKey{}).(*Principal)
+	return principal
+}
+
+// GetPrincipal returns the principal of req, set by an authentication middleware
+func GetPrincipal(req *http.Request) *Principal {
+	return PrincipalFromContext(req.Context())
+}
+
+// errNoCredentials is returned by authenticators when a request carries no credentials
+var errNoCredentials = errors.New("no credentials")
+
+// authenticator authenticates a request, returns errNoCredentials if it carries none of its credentials
+type authenticator func(req *http.Request) (*Principal, error)
+
+// authMiddleware returns a middleware authenticating requests with authenticate.
+// Requests already authenticated by another middleware are passed through, so
+// are requests without credentials if optional is true, otherwise they are
+// rejected by calling challenge and responding with 401
+func authMiddleware(name string, optional bool, logger log.FieldLogger, authenticate authenticator, challenge func(w http.ResponseWriter, err error)) engine.Middleware {
+	if logger == nil {
+		logger = log.StandardLogger()
+	}
+
+	return engine.Middleware{
+		Name:        name,
+		Requires:    []string{engine.RequestIDName},
+		InsertAfter: []string{engine.RequestIDName, engine.AccessLogName, engine.RateLimitName},
+		Handler: func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if GetPrincipal(req) != nil {
+					next.ServeHTTP(w, req)
+					return
+				}
+
+				principal, err := authenticate(req)
+				if err == errNoCredentials && optional {
+					next.ServeHTTP(w, req)
+					return
+				}
+
+				if err != nil {
+					if err != errNoCredentials {
+						logger.WithFields(log.Fields{
+							engine.AccessLogRequestID: engine.GetRequestID(req),
+							"middleware":              name,
+						}).WithError(err).Warn("authentication failed")
+					}
+
+					if challenge != nil {
+						challenge(w, err)
+					}
+					engine.RenderError(w, req, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
+					return
+				}
+
+				next.ServeHTTP(w, req.WithContext(ContextWithPrincipal(req.Context(), principal)))
+			})
+		},
+	}
+}
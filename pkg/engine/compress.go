@@ -0,0 +1,435 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Encodings of Compress
+const (
+	EncodingBrotli  = "br"
+	EncodingGzip    = "gzip"
+	EncodingDeflate = "deflate"
+)
+
+// DefaultCompressMinSize minimum size of compressed responses by default
+const DefaultCompressMinSize = 1024
+
+// DefaultCompressExcludedTypes content types Compress skips by default, they are already compressed
+var DefaultCompressExcludedTypes = []string{
+	"image/*", "video/*", "audio/*", "font/woff", "font/woff2",
+	"application/zip", "application/gzip", "application/x-gzip", "application/x-brotli",
+	"application/zstd", "application/x-7z-compressed", "application/x-rar-compressed", "application/pdf",
+}
+
+// CompressOptions options of Compress
+type CompressOptions struct {
+	// Encodings supported, in order of preference for equally accepted
+	// encodings, br, gzip and deflate by default
+	Encodings []string
+	// Level of gzip and deflate, from -2 to 9, flate's default if it is zero
+	Level int
+	// BrotliQuality from 0 to 11, brotli's default if it is zero
+	BrotliQuality int
+	// MinSize smaller responses aren't compressed, DefaultCompressMinSize if it is zero
+	MinSize int
+	// ExcludedTypes content types not compressed, like "image/*" or "application/zip",
+	// DefaultCompressExcludedTypes if it is nil. image/svg+xml is compressed unless it is listed
+	ExcludedTypes []string
+}
+
+// Compress returns a middleware compressing responses with the encoding
+// negotiated by Accept-Encoding. Responses are buffered up to MinSize to
+// decide, flushing a response compresses it right away, hijacking keeps
+// working. It is declared to run after AccessLog, so logged byte counts are
+// the compressed sizes
+func Compress(options CompressOptions) Middleware {
+	if len(options.Encodings) == 0 {
+		options.Encodings = []string{EncodingBrotli, EncodingGzip, EncodingDeflate}
+	}
+
+	if options.Level == 0 {
+		options.Level = flate.DefaultCompression
+	}
+
+	if options.BrotliQuality == 0 {
+		options.BrotliQuality = brotli.DefaultCompression
+	}
+
+	if options.MinSize == 0 {
+		options.MinSize = DefaultCompressMinSize
+	}
+
+	if options.ExcludedTypes == nil {
+		options.ExcludedTypes = DefaultCompressExcludedTypes
+	}
+
+	pools := map[string]*encoderPool{}
+	for _, encoding := range options.Encodings {
+		pools[encoding] = newEncoderPool(encoding, options)
+	}
+
+	return Middleware{
+		Name:        CompressName,
+		InsertAfter: []string{RequestIDName, AccessLogName},
+		Validate: func() error {
+			for _, encoding := range options.Encodings {
+				if encoding != EncodingBrotli && encoding != EncodingGzip && encoding != EncodingDeflate {
+					return fmt.Errorf("unsupported encoding %v", encoding)
+				}
+			}
+
+			if options.Level < flate.HuffmanOnly || options.Level > flate.BestCompression {
+				return fmt.Errorf("level %v should be from %v to %v", options.Level, flate.HuffmanOnly, flate.BestCompression)
+			}
+
+			if options.BrotliQuality < brotli.BestSpeed || options.BrotliQuality > brotli.BestCompression {
+				return fmt.Errorf("brotli quality %v should be from %v to %v", options.BrotliQuality, brotli.BestSpeed, brotli.BestCompression)
+			}
+
+			if options.MinSize < 0 {
+				return fmt.Errorf("min size %v should be positive", options.MinSize)
+			}
+			return nil
+		},
+		Handler: func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				w.Header().Add("Vary", "Accept-Encoding")
+
+				encoding := negotiateEncoding(req.Header.Get("Accept-Encoding"), options.Encodings)
+				if encoding == "" || req.Method == http.MethodHead {
+					next.ServeHTTP(w, req)
+					return
+				}
+
+				writer := &compressWriter{ResponseWriter: w, pool: pools[encoding], options: &options}
+				defer writer.Close()
+
+				next.ServeHTTP(writer, req)
+			})
+		},
+	}
+}
+
+// negotiateEncoding returns the supported encoding accepted with the highest
+// quality by header, ties are broken by the order of supported
+func negotiateEncoding(header string, supported []string) string {
+	var (
+		qualities = map[string]float64{}
+		wildcard  = -1.0
+	)
+
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.SplitN(part, ";", 2)
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		if name == "" {
+			continue
+		}
+
+		quality := 1.0
+		if value := strings.TrimSpace(fields[len(fields)-1]); len(fields) == 2 && strings.HasPrefix(value, "q=") {
+			q, err := strconv.ParseFloat(value[2:], 64)
+			if err != nil {
+				continue
+			}
+			quality = q
+		}
+
+		if name == "*" {
+			wildcard = quality
+		} else {
+			qualities[name] = quality
+		}
+	}
+
+	var (
+		best        string
+		bestQuality float64
+	)
+
+	for _, encoding := range supported {
+		quality, ok := qualities[encoding]
+		if !ok {
+			quality = wildcard
+		}
+
+		if quality > bestQuality {
+			best, bestQuality = encoding, quality
+		}
+	}
+	return best
+}
+
+// encoder compressing writer of an encoding
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// encoderPool pool of encoders of an encoding, they are expensive to allocate
+type encoderPool struct {
+	encoding string
+	pool     sync.Pool
+}
+
+func newEncoderPool(encoding string, options CompressOptions) *encoderPool {
+	pool := &encoderPool{encoding: encoding}
+	pool.pool.New = func() interface{} {
+		switch encoding {
+		case EncodingBrotli:
+			return brotli.NewWriterLevel(io.Discard, options.BrotliQuality)
+		case EncodingGzip:
+			w, _ := gzip.NewWriterLevel(io.Discard, options.Level)
+			return w
+		default:
+			w, _ := flate.NewWriter(io.Discard, options.Level)
+			return w
+		}
+	}
+	return pool
+}
+
+func (pool *encoderPool) get(w io.Writer) encoder {
+	enc := pool.pool.Get().(encoder)
+	enc.Reset(w)
+	return enc
+}
+
+func (pool *encoderPool) put(enc encoder) {
+	enc.Reset(io.Discard)
+	pool.pool.Put(enc)
+}
+
+// compressWriter buffers a response until it is large enough to be
+// compressed, then writes it through an encoder, otherwise as it is
+type compressWriter struct {
+	http.ResponseWriter
+	pool    *encoderPool
+	options *CompressOptions
+
+	status   int
+	buf      bytes.Buffer
+	decided  bool
+	encoder  encoder
+	hijacked bool
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.decided || cw.status != 0 {
+		if cw.decided && cw.encoder == nil {
+			cw.ResponseWriter.WriteHeader(status)
+		}
+		return
+	}
+
+	// informational responses are sent right away
+	if status >= 100 && status < 200 {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	cw.status = status
+	if !bodyAllowed(status) {
+		cw.decide(false)
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+
+	if cw.decided {
+		if cw.encoder != nil {
+			return cw.encoder.Write(b)
+		}
+		return cw.ResponseWriter.Write(b)
+	}
+
+	n, _ := cw.buf.Write(b)
+	if cw.buf.Len() >= cw.options.MinSize {
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// decide decides whether to compress the response, then writes the header
+// and the buffered body. Responses are compressed only if large enough, unless force is true
+func (cw *compressWriter) decide(force bool) error {
+	cw.decided = true
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+
+	header := cw.Header()
+	if header.Get("Content-Type") == "" && cw.buf.Len() > 0 && header.Get("Content-Encoding") == "" {
+		header.Set("Content-Type", http.DetectContentType(cw.buf.Bytes()))
+	}
+
+	if (force || cw.buf.Len() >= cw.options.MinSize) && cw.compressible() {
+		header.Set("Content-Encoding", cw.pool.encoding)
+		header.Del("Content-Length")
+		cw.encoder = cw.pool.get(cw.ResponseWriter)
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+	if cw.buf.Len() == 0 {
+		return nil
+	}
+
+	var err error
+	if cw.encoder != nil {
+		_, err = cw.encoder.Write(cw.buf.Bytes())
+	} else {
+		_, err = cw.ResponseWriter.Write(cw.buf.Bytes())
+	}
+	cw.buf.Reset()
+	return err
+}
+
+// compressible returns true if the response could be compressed
+func (cw *compressWriter) compressible() bool {
+	header := cw.Header()
+	if !bodyAllowed(cw.status) || cw.status == http.StatusPartialContent || header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" {
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return true
+	}
+
+	for _, excluded := range cw.options.ExcludedTypes {
+		if excluded == mediaType || (strings.HasSuffix(excluded, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(excluded, "*")) && !(mediaType == "image/svg+xml" && excluded == "image/*")) {
+			return false
+		}
+	}
+	return true
+}
+
+// bodyAllowed returns true if a response with status could have a body
+func bodyAllowed(status int) bool {
+	return status != http.StatusNoContent && status != http.StatusNotModified && (status < 100 || status >= 200)
+}
+
+// Flush compresses the buffered response and flushes it, so streamed responses are sent right away
+func (cw *compressWriter) Flush() {
+	if cw.hijacked {
+		return
+	}
+
+	if !cw.decided {
+		cw.decide(cw.buf.Len() > 0)
+	}
+
+	if cw.encoder != nil {
+		cw.encoder.Flush()
+	}
+
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack hijacks the wrapped writer's connection if it is a http.Hijacker
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := cw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T doesn't support hijacking", cw.ResponseWriter)
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err == nil {
+		cw.hijacked = true
+	}
+	return conn, rw, err
+}
+
+// Unwrap returns the wrapped writer, for http.ResponseController
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// Close writes the buffered response, and finishes the compressed stream
+func (cw *compressWriter) Close() error {
+	if cw.hijacked {
+		return nil
+	}
+
+	if !cw.decided {
+		if cw.status == 0 && cw.buf.Len() == 0 {
+			// nothing written, let net/http write the default response
+			return nil
+		}
+
+		if err := cw.decide(false); err != nil {
+			return err
+		}
+	}
+
+	if cw.encoder == nil {
+		return nil
+	}
+
+	err := cw.encoder.Close()
+	cw.pool.put(cw.encoder)
+	cw.encoder = nil
+	return err
+}
+
+func init() {
+	RegisterFactory(CompressName, Factory{
+		Description: "compresses responses with brotli, gzip or deflate",
+		Params: []Param{
+			{Name: "encodings", Type: StringListParam, Description: "supported encodings in order of preference"},
+			{Name: "level", Type: IntParam, Description: "level of gzip and deflate"},
+			{Name: "brotli_quality", Type: IntParam},
+			{Name: "min_size", Type: IntParam, Default: DefaultCompressMinSize},
+			{Name: "excluded_types", Type: StringListParam},
+		},
+		New: func(params Params) (Middleware, error) {
+			return Compress(CompressOptions{
+				Encodings:     params.Strings("encodings"),
+				Level:         params.Int("level"),
+				BrotliQuality: params.Int("brotli_quality"),
+				MinSize:       params.Int("min_size"),
+				ExcludedTypes: params.Strings("excluded_types"),
+			}), nil
+		},
+	})
+}
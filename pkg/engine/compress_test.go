@@ -0,0 +1,180 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	supported := []string{EncodingBrotli, EncodingGzip, EncodingDeflate}
+
+	for header, expected := range map[string]string{
+		"":                          "",
+		"gzip":                      EncodingGzip,
+		"gzip, deflate, br":         EncodingBrotli,
+		"gzip;q=1.0, br;q=0.5":      EncodingGzip,
+		"br;q=0, *":                 EncodingGzip,
+		"identity":                  "",
+		"*;q=0":                     "",
+		"DEFLATE;q=0.8, gzip;q=0.2": EncodingDeflate,
+	} {
+		if encoding := negotiateEncoding(header, supported); encoding != expected {
+			t.Errorf("Expected %q for Accept-Encoding %q, but got %q", expected, header, encoding)
+		}
+	}
+}
+
+func TestCompress(t *testing.T) {
+	var (
+		buf    = &bytes.Buffer{}
+		logger = log.New()
+		body   = strings.Repeat("hello world ", 200)
+		stack  = &MiddlewareStack{}
+	)
+	logger.Out = buf
+
+	stack.Use(Compress(CompressOptions{}))
+	stack.Use(AccessLog(AccessLogOptions{Logger: logger, Format: AccessLogJSON}))
+
+	if str := stack.String(); str != "MiddlewareStack: access_log, compress" {
+		t.Errorf("Expected compress to run after access_log, but got %v", str)
+	}
+
+	handler := stack.MustApply(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/small":
+			io.WriteString(w, "small")
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			io.WriteString(w, body)
+		default:
+			w.Header().Set("Content-Type", "text/plain")
+			io.WriteString(w, body[:100])
+			io.WriteString(w, body[100:])
+		}
+	}))
+
+	for path, encoding := range map[string]string{"/": EncodingGzip, "/small": "", "/image": ""} {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		handler.ServeHTTP(recorder, req)
+
+		if recorder.Header().Get("Content-Encoding") != encoding || recorder.Header().Get("Vary") != "Accept-Encoding" {
+			t.Errorf("Expected %v to be encoded as %q, but got %v", path, encoding, recorder.Header())
+		}
+
+		if encoding == EncodingGzip {
+			reader, err := gzip.NewReader(recorder.Body)
+			if err != nil {
+				t.Fatalf("Failed to read gzip response, got %v", err)
+			}
+
+			if decoded, _ := io.ReadAll(reader); string(decoded) != body {
+				t.Errorf("Expected decoded body to be the original, but got %v bytes", len(decoded))
+			}
+		}
+	}
+
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if strings.Contains(line, `"path":"/",`) && strings.Contains(line, `"bytes":2400,`) {
+			t.Errorf("Expected logged byte count to be the compressed size, but got %v", line)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip, br")
+	handler.ServeHTTP(recorder, req)
+
+	if decoded, _ := io.ReadAll(brotli.NewReader(recorder.Body)); recorder.Header().Get("Content-Encoding") != EncodingBrotli || string(decoded) != body {
+		t.Errorf("Expected brotli response, but got %v", recorder.Header())
+	}
+}
+
+func TestCompressFlushAndHijack(t *testing.T) {
+	stack := &MiddlewareStack{}
+	stack.Use(Compress(CompressOptions{}))
+
+	flushed := make(chan struct{})
+	server := httptest.NewServer(stack.MustApply(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/hijack" {
+			conn, rw, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Errorf("Expected hijacking to work, but got %v", err)
+				return
+			}
+			defer conn.Close()
+			rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: test\r\nConnection: Upgrade\r\n\r\n")
+			rw.Flush()
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+		<-flushed
+		io.WriteString(w, "data: second\n\n")
+	})))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL+"/events", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("Failed to request events, got %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.Header.Get("Content-Encoding") != EncodingGzip {
+		t.Errorf("Expected flushed stream to be compressed, but got %v", resp.Header)
+	}
+
+	reader, _ := gzip.NewReader(resp.Body)
+	line, _ := bufio.NewReader(reader).ReadString('\n')
+	close(flushed)
+	if line != "data: first\n" {
+		t.Errorf("Expected first event before the response ends, but got %q", line)
+	}
+
+	req, _ = http.NewRequest("GET", server.URL+"/hijack", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err = http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("Failed to request hijack, got %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("Expected hijacked connection to switch protocols, but got %v", resp.Status)
+	}
+}
@@ -0,0 +1,175 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"sigs.k8s.io/yaml"
+)
+
+// StackConfig declarative composition of a MiddlewareStack, it could be
+// written in YAML or JSON, e.g.
+//
+//	middlewares:
+//	- name: request_id
+//	  type: request_id
+//	- name: access_log
+//	  type: access_log
+//	  params:
+//	    format: json
+//	  insert_after: [request_id]
+type StackConfig struct {
+	Middlewares []MiddlewareConfig `json:"middlewares"`
+}
+
+// MiddlewareConfig configuration of a middleware in a StackConfig
+type MiddlewareConfig struct {
+	Name string `json:"name"`
+	// Type is the type name of the factory building the middleware, Name is used if it is blank
+	Type   string                 `json:"type,omitempty"`
+	Params map[string]interface{} `json:"params,omitempty"`
+
+	InsertBefore   []string `json:"insert_before,omitempty"`
+	InsertAfter    []string `json:"insert_after,omitempty"`
+	Requires       []string `json:"requires,omitempty"`
+	OptionalBefore []string `json:"optional_before,omitempty"`
+	OptionalAfter  []string `json:"optional_after,omitempty"`
+	Conflicts      []string `json:"conflicts,omitempty"`
+	Priority       int      `json:"priority,omitempty"`
+
+	Paths   []string `json:"paths,omitempty"`
+	Methods []string `json:"methods,omitempty"`
+	Hosts   []string `json:"hosts,omitempty"`
+
+	Disabled bool `json:"disabled,omitempty"`
+}
+
+// ParseConfig parse a YAML or JSON stack configuration, unknown fields are rejected
+func ParseConfig(data []byte) (*StackConfig, error) {
+	var config StackConfig
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return nil, fmt.Errorf("invalid middleware stack config: %w", err)
+	}
+	return &config, nil
+}
+
+// LoadConfig load a YAML or JSON stack configuration from file path
+func LoadConfig(path string) (*StackConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(data)
+}
+
+// LoadStack load stack configuration from file path, and build it with DefaultRegistry
+func LoadStack(path string) (*MiddlewareStack, error) {
+	config, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	return DefaultRegistry.Build(config)
+}
+
+// Build builds a stack from config with the registry's factories. Parameters
+// are validated against each factory's schema, and the stack is compiled once,
+// so it returns every problem of the configuration in a single error
+func (registry *Registry) Build(config *StackConfig) (*MiddlewareStack, error) {
+	var (
+		errs  []error
+		stack = &MiddlewareStack{}
+	)
+
+	for _, mc := range config.Middlewares {
+		middleware, err := registry.build(mc)
+		if err != nil {
+			errs = append(errs, &ConfigError{Middleware: mc.Name, Err: err})
+			continue
+		}
+
+		if err := stack.Use(middleware); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if mc.Disabled {
+			stack.Disable(mc.Name)
+		}
+	}
+
+	if len(errs) == 0 {
+		if _, err := stack.Compile(http.NotFoundHandler()); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := newStackError(errs); err != nil {
+		return nil, err
+	}
+	return stack, nil
+}
+
+func (registry *Registry) build(mc MiddlewareConfig) (Middleware, error) {
+	if mc.Name == "" {
+		return Middleware{}, fmt.Errorf("name is required")
+	}
+
+	typeName := mc.Type
+	if typeName == "" {
+		typeName = mc.Name
+	}
+
+	factory, ok := registry.Lookup(typeName)
+	if !ok {
+		return Middleware{}, fmt.Errorf("unknown middleware type %v", typeName)
+	}
+
+	params, errs := factory.validate(mc.Params)
+	if err := newStackError(errs); err != nil {
+		return Middleware{}, err
+	}
+
+	params[buildParam] = &buildScope{registry: registry, name: mc.Name}
+	middleware, err := factory.New(params)
+	if err != nil {
+		return Middleware{}, err
+	}
+
+	// ordering and scope from the configuration are added to the factory's defaults
+	middleware.Name = mc.Name
+	middleware.InsertBefore = append(append([]string{}, middleware.InsertBefore...), mc.InsertBefore...)
+	middleware.InsertAfter = append(append([]string{}, middleware.InsertAfter...), mc.InsertAfter...)
+	middleware.Requires = append(append([]string{}, middleware.Requires...), mc.Requires...)
+	middleware.OptionalBefore = append(append([]string{}, middleware.OptionalBefore...), mc.OptionalBefore...)
+	middleware.OptionalAfter = append(append([]string{}, middleware.OptionalAfter...), mc.OptionalAfter...)
+	middleware.Conflicts = append(append([]string{}, middleware.Conflicts...), mc.Conflicts...)
+	middleware.Paths = append(append([]string{}, middleware.Paths...), mc.Paths...)
+	middleware.Methods = append(append([]string{}, middleware.Methods...), mc.Methods...)
+	middleware.Hosts = append(append([]string{}, middleware.Hosts...), mc.Hosts...)
+	if mc.Priority != 0 {
+		middleware.Priority = mc.Priority
+	}
+
+	return middleware, nil
+}
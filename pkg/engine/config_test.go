@@ -0,0 +1,178 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestRegistry() *Registry {
+	registry := &Registry{}
+	registry.Register("header", Factory{
+		Params: []Param{
+			{Name: "value", Type: StringParam, Required: true},
+			{Name: "repeat", Type: IntParam, Default: 1},
+			{Name: "timeout", Type: DurationParam},
+			{Name: "methods", Type: StringListParam},
+		},
+		New: func(params Params) (Middleware, error) {
+			if params.Int("repeat") < 1 {
+				return Middleware{}, fmt.Errorf("repeat should be positive")
+			}
+			return headerMiddleware(params.String("value")), nil
+		},
+	})
+	return registry
+}
+
+func TestBuildStackFromConfig(t *testing.T) {
+	config, err := ParseConfig([]byte(`
+middlewares:
+- name: cookie
+  type: header
+  params:
+    value: cookie
+    timeout: 3s
+- name: auth
+  type: header
+  params: {value: auth, methods: [POST]}
+  insert_before: [cookie]
+  paths: [/api/]
+- name: debug
+  type: header
+  params: {value: debug}
+  disabled: true
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse config, got %v", err)
+	}
+
+	stack, err := newTestRegistry().Build(config)
+	if err != nil {
+		t.Fatalf("Failed to build stack, got %v", err)
+	}
+
+	if str := stack.String(); str != "MiddlewareStack: auth, cookie, debug (disabled)" {
+		t.Errorf("Expected stack auth, cookie, debug (disabled), but got %v", str)
+	}
+
+	if names := serveLive(stack.MustApply(http.NotFoundHandler())); fmt.Sprint(names) != "[cookie]" {
+		t.Errorf("Expected only cookie to be applied to /, but got %v", names)
+	}
+}
+
+func TestBuildStackFromJSONConfig(t *testing.T) {
+	config, err := ParseConfig([]byte(`{"middlewares": [{"name": "cookie", "type": "header", "params": {"value": "cookie", "repeat": 2}}]}`))
+	if err != nil {
+		t.Fatalf("Failed to parse config, got %v", err)
+	}
+
+	if _, err := newTestRegistry().Build(config); err != nil {
+		t.Errorf("Failed to build stack, got %v", err)
+	}
+}
+
+func TestBuildStackFromInvalidConfig(t *testing.T) {
+	if _, err := ParseConfig([]byte(`middlewares: [{name: cookie, unknown: true}]`)); err == nil {
+		t.Errorf("Should return error for unknown fields")
+	}
+
+	config, err := ParseConfig([]byte(`
+middlewares:
+- name: cookie
+  type: header
+  params: {repeat: 1.5, timeout: soon, extra: 1}
+- name: session
+- name: auth
+  type: header
+  params: {value: auth, repeat: 0}
+- name: flash
+  type: header
+  params: {value: flash}
+  requires: [cookie]
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse config, got %v", err)
+	}
+
+	_, err = newTestRegistry().Build(config)
+
+	var stackError *StackError
+	if !errors.As(err, &stackError) || len(stackError.Errors) != 3 {
+		t.Fatalf("Expected errors of cookie, session and auth, but got %v", err)
+	}
+
+	var paramError *ParamError
+	if !errors.As(stackError.Errors[0], &paramError) || paramError.Param != "value" {
+		t.Errorf("Expected missing param value, but got %v", stackError.Errors[0])
+	}
+
+	if err := stackError.Errors[0].Error(); err != "middleware cookie: param value is required; param repeat expects int, but got 1.5; param timeout is an invalid duration: time: invalid duration \"soon\"; param extra is unknown" {
+		t.Errorf("Unexpected errors of cookie, got %v", err)
+	}
+}
+
+func TestParams(t *testing.T) {
+	params, errs := newTestRegistry().factories["header"].validate(map[string]interface{}{"value": "cookie", "timeout": "1m", "methods": []interface{}{"GET"}})
+	if len(errs) > 0 {
+		t.Fatalf("Failed to validate params, got %v", errs)
+	}
+
+	if params.String("value") != "cookie" || params.Int("repeat") != 1 || params.Duration("timeout") != time.Minute || fmt.Sprint(params.Strings("methods")) != "[GET]" {
+		t.Errorf("Unexpected params, got %v", params)
+	}
+}
+
+func TestConfigWatcherReload(t *testing.T) {
+	var (
+		path    = filepath.Join(t.TempDir(), "middlewares.yml")
+		stack   = &MiddlewareStack{}
+		watcher = &ConfigWatcher{Path: path, Stack: stack, Registry: newTestRegistry()}
+	)
+
+	os.WriteFile(path, []byte("middlewares: [{name: cookie, type: header, params: {value: cookie}}]"), 0o600)
+	if err := watcher.Load(); err != nil {
+		t.Fatalf("Failed to load config, got %v", err)
+	}
+
+	handler, err := stack.ApplyLive(http.NotFoundHandler())
+	if err != nil {
+		t.Fatalf("Failed to apply middlewares, got %v", err)
+	}
+
+	os.WriteFile(path, []byte("middlewares: [{name: cookie, type: header, params: {value: cookie}}, {name: auth, type: header, requires: [session]}]"), 0o600)
+	watcher.reload()
+	if names := serveLive(handler); fmt.Sprint(names) != "[cookie]" || handler.Err() != nil {
+		t.Errorf("Invalid config should be rejected, but got %v, %v", names, handler.Err())
+	}
+
+	os.WriteFile(path, []byte("middlewares: [{name: auth, type: header, params: {value: auth}}, {name: cookie, type: header, params: {value: cookie}}]"), 0o600)
+	watcher.reload()
+	if names := serveLive(handler); fmt.Sprint(names) != "[auth cookie]" {
+		t.Errorf("Expected valid config to be reloaded, but got %v", names)
+	}
+}
@@ -0,0 +1,276 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSOptions options of CORS
+type CORSOptions struct {
+	// AllowedOrigins exact origins like "https://example.com", wildcard
+	// subdomains like "https://*.example.com", or "*" for any origin
+	AllowedOrigins []string
+	// AllowedOriginPatterns case insensitive regular expressions matching whole
+	// origins, patterns matching any origin are rejected with AllowCredentials
+	AllowedOriginPatterns []string
+	// AllowedMethods is GET, HEAD and POST if it is empty
+	AllowedMethods []string
+	// AllowedHeaders request headers allowed in preflight requests, "*" allows
+	// any header, it is Accept, Accept-Language, Content-Language, Content-Type
+	// and X-Request-ID if it is empty
+	AllowedHeaders []string
+	// ExposedHeaders response headers readable by scripts
+	ExposedHeaders   []string
+	AllowCredentials bool
+	// MaxAge how long preflight responses could be cached, not sent if it is zero
+	MaxAge time.Duration
+}
+
+// CORS returns a middleware handling cross-origin requests, it answers
+// preflight requests itself, and is declared to run before authentication
+// middlewares, so preflight requests never need credentials. Unsafe options,
+// like "*" origins with AllowCredentials, are rejected when the stack is compiled
+func CORS(options CORSOptions) Middleware {
+	if len(options.AllowedMethods) == 0 {
+		options.AllowedMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+	}
+
+	if len(options.AllowedHeaders) == 0 {
+		options.AllowedHeaders = []string{"Accept", "Accept-Language", "Content-Language", "Content-Type", RequestIDHeader}
+	}
+
+	policy, err := newCORSPolicy(options)
+
+	return Middleware{
+		Name:         CORSName,
+		InsertAfter:  []string{RequestIDName, AccessLogName},
+		InsertBefore: append([]string{RateLimitName}, authNames...),
+		Validate: func() error {
+			return err
+		},
+		Handler: func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				origin := req.Header.Get("Origin")
+				if req.Method == http.MethodOptions && origin != "" && req.Header.Get("Access-Control-Request-Method") != "" {
+					policy.preflight(w, req, origin)
+					return
+				}
+
+				w.Header().Add("Vary", "Origin")
+				if origin != "" && policy.allowOrigin(origin) {
+					policy.setOrigin(w.Header(), origin)
+					if len(options.ExposedHeaders) > 0 {
+						w.Header().Set("Access-Control-Expose-Headers", strings.Join(options.ExposedHeaders, ", "))
+					}
+				}
+				next.ServeHTTP(w, req)
+			})
+		},
+	}
+}
+
+type corsPolicy struct {
+	options   CORSOptions
+	anyOrigin bool
+	origins   map[string]bool
+	wildcards [][2]string
+	patterns  []*regexp.Regexp
+	anyHeader bool
+	methods   map[string]bool
+	headers   map[string]bool
+}
+
+// corsCanaryOrigins origins no intended pattern should match, a pattern
+// matching them would allow arbitrary origins
+var corsCanaryOrigins = []string{"https://invalid.example", "http://invalid.example", "null"}
+
+func newCORSPolicy(options CORSOptions) (*corsPolicy, error) {
+	policy := &corsPolicy{options: options, origins: map[string]bool{}, methods: map[string]bool{}, headers: map[string]bool{}}
+
+	if len(options.AllowedOrigins) == 0 && len(options.AllowedOriginPatterns) == 0 {
+		return policy, errors.New("no allowed origins")
+	}
+
+	for _, origin := range options.AllowedOrigins {
+		origin = strings.ToLower(origin)
+		switch {
+		case origin == "*":
+			if options.AllowCredentials {
+				return policy, errors.New(`"*" origin is not allowed with credentials`)
+			}
+			policy.anyOrigin = true
+		case origin == "null" && options.AllowCredentials:
+			return policy, errors.New(`"null" origin is not allowed with credentials`)
+		case strings.Contains(origin, "*"):
+			idx := strings.Index(origin, "://*.")
+			if idx < 0 || strings.Count(origin, "*") > 1 {
+				return policy, fmt.Errorf("invalid wildcard origin %v, should be like https://*.example.com", origin)
+			}
+			policy.wildcards = append(policy.wildcards, [2]string{origin[:idx+3], origin[idx+4:]})
+		default:
+			policy.origins[origin] = true
+		}
+	}
+
+	for _, pattern := range options.AllowedOriginPatterns {
+		// origins are matched in lower case, like hosts, so patterns are case insensitive
+		re, err := regexp.Compile("(?i)^(?:" + pattern + ")$")
+		if err != nil {
+			return policy, fmt.Errorf("invalid origin pattern %v: %v", pattern, err)
+		}
+
+		if options.AllowCredentials {
+			for _, canary := range corsCanaryOrigins {
+				if re.MatchString(canary) {
+					return policy, fmt.Errorf("origin pattern %v matches any origin, like %v, it is not allowed with credentials", pattern, canary)
+				}
+			}
+		}
+		policy.patterns = append(policy.patterns, re)
+	}
+
+	for _, method := range options.AllowedMethods {
+		policy.methods[strings.ToUpper(method)] = true
+	}
+
+	for _, header := range options.AllowedHeaders {
+		if header == "*" {
+			policy.anyHeader = true
+		}
+		policy.headers[http.CanonicalHeaderKey(header)] = true
+	}
+
+	if options.MaxAge < 0 {
+		return policy, fmt.Errorf("max age %v should be positive", options.MaxAge)
+	}
+	return policy, nil
+}
+
+// allowOrigin returns true if origin is allowed
+func (policy *corsPolicy) allowOrigin(origin string) bool {
+	if policy.anyOrigin {
+		return true
+	}
+
+	origin = strings.ToLower(origin)
+	if policy.origins[origin] {
+		return true
+	}
+
+	for _, wildcard := range policy.wildcards {
+		if strings.HasPrefix(origin, wildcard[0]) && strings.HasSuffix(origin, wildcard[1]) && len(origin) > len(wildcard[0])+len(wildcard[1]) {
+			if subdomain := origin[len(wildcard[0]) : len(origin)-len(wildcard[1])]; !strings.ContainsAny(subdomain, "/:@") {
+				return true
+			}
+		}
+	}
+
+	for _, pattern := range policy.patterns {
+		if pattern.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// setOrigin sets the allowed origin headers of a response to origin
+func (policy *corsPolicy) setOrigin(header http.Header, origin string) {
+	if policy.anyOrigin {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+
+	if policy.options.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// preflight answers a preflight request, without CORS headers if the origin,
+// method or any of the headers isn't allowed
+func (policy *corsPolicy) preflight(w http.ResponseWriter, req *http.Request, origin string) {
+	header := w.Header()
+	header.Add("Vary", "Origin")
+	header.Add("Vary", "Access-Control-Request-Method")
+	header.Add("Vary", "Access-Control-Request-Headers")
+
+	method := strings.ToUpper(req.Header.Get("Access-Control-Request-Method"))
+	if !policy.allowOrigin(origin) || !policy.methods[method] {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var requestedHeaders []string
+	for _, value := range req.Header.Values("Access-Control-Request-Headers") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				if !policy.anyHeader && !policy.headers[http.CanonicalHeaderKey(name)] {
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+				requestedHeaders = append(requestedHeaders, name)
+			}
+		}
+	}
+
+	policy.setOrigin(header, origin)
+	header.Set("Access-Control-Allow-Methods", method)
+	if len(requestedHeaders) > 0 {
+		header.Set("Access-Control-Allow-Headers", strings.Join(requestedHeaders, ", "))
+	}
+	if policy.options.MaxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(int(policy.options.MaxAge.Seconds())))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func init() {
+	RegisterFactory(CORSName, Factory{
+		Description: "handles cross-origin requests and preflight requests",
+		Params: []Param{
+			{Name: "allowed_origins", Type: StringListParam, Description: `exact origins, wildcard subdomains like "https://*.example.com", or "*"`},
+			{Name: "allowed_origin_patterns", Type: StringListParam, Description: "regular expressions matching whole origins"},
+			{Name: "allowed_methods", Type: StringListParam},
+			{Name: "allowed_headers", Type: StringListParam},
+			{Name: "exposed_headers", Type: StringListParam},
+			{Name: "allow_credentials", Type: BoolParam},
+			{Name: "max_age", Type: DurationParam},
+		},
+		New: func(params Params) (Middleware, error) {
+			return CORS(CORSOptions{
+				AllowedOrigins:        params.Strings("allowed_origins"),
+				AllowedOriginPatterns: params.Strings("allowed_origin_patterns"),
+				AllowedMethods:        params.Strings("allowed_methods"),
+				AllowedHeaders:        params.Strings("allowed_headers"),
+				ExposedHeaders:        params.Strings("exposed_headers"),
+				AllowCredentials:      params.Bool("allow_credentials"),
+				MaxAge:                params.Duration("max_age"),
+			}), nil
+		},
+	})
+}
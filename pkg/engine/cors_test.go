@@ -0,0 +1,125 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCORS(t *testing.T) {
+	stack := &MiddlewareStack{}
+	stack.Use(Middleware{Name: BearerAuthName, Handler: func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.Header.Get("Authorization") == "" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, req)
+		})
+	}})
+	stack.Use(CORS(CORSOptions{
+		AllowedOrigins:        []string{"https://example.com", "https://*.example.org"},
+		AllowedOriginPatterns: []string{`https://App-\d+\.example\.net`},
+		AllowedMethods:        []string{"GET", "PUT"},
+		ExposedHeaders:        []string{"X-Total"},
+		AllowCredentials:      true,
+		MaxAge:                time.Hour,
+	}))
+
+	if str := stack.String(); str != "MiddlewareStack: cors, bearer_auth" {
+		t.Errorf("Expected cors to run before authentication, but got %v", str)
+	}
+
+	handler := stack.MustApply(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+
+	for origin, allowed := range map[string]bool{
+		"https://example.com":           true,
+		"https://api.example.org":       true,
+		"https://a.b.example.org":       true,
+		"https://example.org":           false,
+		"http://api.example.org":        false,
+		"https://app-12.example.net":    true,
+		"https://APP-7.example.net":     true,
+		"https://app-x.example.net":     false,
+		"https://evil.com/.example.org": false,
+	} {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest("OPTIONS", "/", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", "PUT")
+		req.Header.Set("Access-Control-Request-Headers", "content-type, x-request-id")
+		handler.ServeHTTP(recorder, req)
+
+		if recorder.Code != http.StatusNoContent {
+			t.Errorf("Expected preflight for %v to be answered without credentials, but got %v", origin, recorder.Code)
+		}
+
+		if got := recorder.Header().Get("Access-Control-Allow-Origin") == origin; got != allowed {
+			t.Errorf("Expected origin %v allowed to be %v, but got headers %v", origin, allowed, recorder.Header())
+		}
+
+		if allowed && (recorder.Header().Get("Access-Control-Max-Age") != "3600" || recorder.Header().Get("Access-Control-Allow-Headers") != "content-type, x-request-id") {
+			t.Errorf("Expected preflight headers, but got %v", recorder.Header())
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("OPTIONS", "/", nil)
+	req.Header.Set("Origin", "https://example.com")
+	req.Header.Set("Access-Control-Request-Method", "DELETE")
+	handler.ServeHTTP(recorder, req)
+	if recorder.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("Expected method not allowed, but got %v", recorder.Header())
+	}
+
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Origin", "https://example.com")
+	req.Header.Set("Authorization", "Bearer token")
+	handler.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK || recorder.Header().Get("Access-Control-Allow-Credentials") != "true" || recorder.Header().Get("Access-Control-Expose-Headers") != "X-Total" {
+		t.Errorf("Expected CORS headers on actual request, but got %v %v", recorder.Code, recorder.Header())
+	}
+}
+
+func TestCORSInvalidOptions(t *testing.T) {
+	for _, options := range []CORSOptions{
+		{},
+		{AllowedOrigins: []string{"*"}, AllowCredentials: true},
+		{AllowedOrigins: []string{"https://*example.com"}},
+		{AllowedOriginPatterns: []string{"("}},
+		{AllowedOriginPatterns: []string{".*"}, AllowCredentials: true},
+		{AllowedOriginPatterns: []string{`https?://.+`}, AllowCredentials: true},
+		{AllowedOriginPatterns: []string{`null|https://app\.example\.com`}, AllowCredentials: true},
+	} {
+		stack := &MiddlewareStack{}
+		stack.Use(CORS(options))
+
+		var invalidErr *InvalidMiddlewareError
+		if _, err := stack.Compile(http.NotFoundHandler()); !errors.As(err, &invalidErr) {
+			t.Errorf("Expected %+v to be invalid, but got %v", options, err)
+		}
+	}
+}
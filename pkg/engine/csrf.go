@@ -0,0 +1,393 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// CSRFMode how CSRF keeps the expected token
+type CSRFMode string
+
+// Modes of CSRF
+const (
+	// CSRFDoubleSubmit keeps the token in a cookie, requests must submit it again
+	CSRFDoubleSubmit CSRFMode = "double_submit"
+	// CSRFSynchronizer keeps the token in the session, see CSRFOptions.TokenStore
+	CSRFSynchronizer CSRFMode = "synchronizer"
+)
+
+// Defaults of CSRFOptions
+const (
+	DefaultCSRFCookieName = "csrf_token"
+	DefaultCSRFHeaderName = "X-CSRF-Token"
+	DefaultCSRFFieldName  = "csrf_token"
+)
+
+// csrfTokenLength length of raw CSRF tokens
+const csrfTokenLength = 32
+
+// CSRFTokenStore keeps the CSRF token of a session, for CSRFSynchronizer mode
+type CSRFTokenStore interface {
+	// Token returns the token of req's session, or a blank string if it has none
+	Token(req *http.Request) (string, error)
+	// SaveToken saves token in req's session
+	SaveToken(w http.ResponseWriter, req *http.Request, token string) error
+}
+
+// CSRFOptions options of CSRF
+type CSRFOptions struct {
+	// Mode is CSRFDoubleSubmit if it is blank
+	Mode CSRFMode
+	// TokenStore keeps tokens in CSRFSynchronizer mode, the session of the
+	// Session middleware if it is nil
+	TokenStore CSRFTokenStore
+
+	// CookieName cookie of the token in CSRFDoubleSubmit mode, DefaultCSRFCookieName if it is blank
+	CookieName   string
+	CookieDomain string
+	// CookiePath is "/" if it is blank
+	CookiePath string
+
+	// HeaderName header submitting the token, DefaultCSRFHeaderName if it is blank
+	HeaderName string
+	// FieldName form field submitting the token, DefaultCSRFFieldName if it is blank
+	FieldName string
+
+	// ExemptPaths path prefixes not checked, like webhook endpoints, matched
+	// by whole segments against the cleaned path like Middleware.Paths
+	ExemptPaths []string
+	// Exempt returns true for requests not checked
+	Exempt func(req *http.Request) bool
+
+	// TrustedOrigins origins allowed besides the request's own, like "https://app.example.com"
+	TrustedOrigins []string
+	// TrustProxy trusts X-Forwarded-Proto to tell HTTPS requests
+	TrustProxy bool
+
+	// Logger is log.StandardLogger() if it is nil
+	Logger log.FieldLogger
+}
+
+// sessionCSRFTokenStore keeps CSRF tokens as value key of the session of the Session middleware
+type sessionCSRFTokenStore struct {
+	key string
+}
+
+func (store sessionCSRFTokenStore) Token(req *http.Request) (string, error) {
+	session := GetSession(req)
+	if session == nil {
+		return "", errors.New("request has no session")
+	}
+	return session.GetString(store.key), nil
+}
+
+func (store sessionCSRFTokenStore) SaveToken(w http.ResponseWriter, req *http.Request, token string) error {
+	session := GetSession(req)
+	if session == nil {
+		return errors.New("request has no session")
+	}
+	session.Set(store.key, token)
+	return nil
+}
+
+type csrfContext struct {
+	token     string
+	fieldName string
+}
+
+type csrfKey struct{}
+
+// CSRFToken returns the CSRF token of req to submit with forms or the header,
+// it is masked differently for every call, so it doesn't leak through compression
+func CSRFToken(req *http.Request) string {
+	if ctx, ok := req.Context().Value(csrfKey{}).(*csrfContext); ok {
+		return maskCSRFToken(ctx.token)
+	}
+	return ""
+}
+
+// CSRFTemplateField returns a hidden form field with the CSRF token of req, for templates
+func CSRFTemplateField(req *http.Request) template.HTML {
+	if ctx, ok := req.Context().Value(csrfKey{}).(*csrfContext); ok {
+		return template.HTML(fmt.Sprintf(`<input type="hidden" name="%v" value="%v">`, template.HTMLEscapeString(ctx.fieldName), maskCSRFToken(ctx.token)))
+	}
+	return ""
+}
+
+// CSRFTemplateFuncs returns template functions csrf_token and csrf_field for req
+func CSRFTemplateFuncs(req *http.Request) template.FuncMap {
+	return template.FuncMap{
+		"csrf_token": func() string { return CSRFToken(req) },
+		"csrf_field": func() template.HTML { return CSRFTemplateField(req) },
+	}
+}
+
+// CSRF returns a middleware protecting unsafe requests from cross-site
+// request forgery, they must come from the same or a trusted origin, and
+// submit the token in a header or a form field. It requires the session
+// middleware in CSRFSynchronizer mode
+func CSRF(options CSRFOptions) Middleware {
+	if options.Mode == "" {
+		options.Mode = CSRFDoubleSubmit
+	}
+
+	if options.CookieName == "" {
+		options.CookieName = DefaultCSRFCookieName
+	}
+
+	if options.CookiePath == "" {
+		options.CookiePath = "/"
+	}
+
+	if options.HeaderName == "" {
+		options.HeaderName = DefaultCSRFHeaderName
+	}
+
+	if options.FieldName == "" {
+		options.FieldName = DefaultCSRFFieldName
+	}
+
+	if options.Logger == nil {
+		options.Logger = log.StandardLogger()
+	}
+
+	if options.TokenStore == nil {
+		options.TokenStore = sessionCSRFTokenStore{key: options.FieldName}
+	}
+
+	middleware := Middleware{
+		Name:        CSRFName,
+		InsertAfter: []string{RequestIDName, AccessLogName, SessionName},
+		Validate: func() error {
+			if options.Mode != CSRFDoubleSubmit && options.Mode != CSRFSynchronizer {
+				return fmt.Errorf("unknown mode %v", options.Mode)
+			}
+
+			for _, origin := range options.TrustedOrigins {
+				if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" {
+					return fmt.Errorf("invalid trusted origin %v, should be like https://example.com", origin)
+				}
+			}
+			return nil
+		},
+		Handler: func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				w.Header().Add("Vary", "Cookie")
+
+				token, err := options.token(w, req)
+				if err != nil {
+					options.Logger.WithError(err).WithField(AccessLogRequestID, GetRequestID(req)).Error("cannot load CSRF token")
+					RenderError(w, req, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+					return
+				}
+
+				req = req.WithContext(context.WithValue(req.Context(), csrfKey{}, &csrfContext{token: token, fieldName: options.FieldName}))
+
+				if !safeMethod(req.Method) && !options.exempt(req) {
+					if err := options.verify(req, token); err != nil {
+						options.Logger.WithFields(log.Fields{
+							AccessLogRequestID: GetRequestID(req),
+							AccessLogMethod:    req.Method,
+							AccessLogPath:      req.URL.Path,
+						}).WithError(err).Warn("CSRF check failed")
+						RenderError(w, req, http.StatusForbidden, "CSRF check failed")
+						return
+					}
+				}
+
+				next.ServeHTTP(w, req)
+			})
+		},
+	}
+
+	if options.Mode == CSRFSynchronizer {
+		middleware.Requires = []string{SessionName}
+	}
+	return middleware
+}
+
+// token returns the raw token of req, a new one is generated and saved if it has none
+func (options CSRFOptions) token(w http.ResponseWriter, req *http.Request) (string, error) {
+	var token string
+	if options.Mode == CSRFSynchronizer {
+		stored, err := options.TokenStore.Token(req)
+		if err != nil {
+			return "", err
+		}
+		token = stored
+	} else if cookie, err := req.Cookie(options.CookieName); err == nil {
+		token = cookie.Value
+	}
+
+	if raw, err := base64.RawURLEncoding.DecodeString(token); err == nil && len(raw) == csrfTokenLength {
+		return base64.RawURLEncoding.EncodeToString(raw), nil
+	}
+
+	raw := make([]byte, csrfTokenLength)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(raw)
+
+	if options.Mode == CSRFSynchronizer {
+		return token, options.TokenStore.SaveToken(w, req, token)
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     options.CookieName,
+		Value:    token,
+		Path:     options.CookiePath,
+		Domain:   options.CookieDomain,
+		Secure:   isHTTPS(req, options.TrustProxy),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return token, nil
+}
+
+func (options CSRFOptions) exempt(req *http.Request) bool {
+	return matchAny(options.ExemptPaths, req.URL.Path, matchPathPrefix) || (options.Exempt != nil && options.Exempt(req))
+}
+
+// verify checks the origin of req and the token it submits
+func (options CSRFOptions) verify(req *http.Request, token string) error {
+	scheme := "http"
+	if isHTTPS(req, options.TrustProxy) {
+		scheme = "https"
+	}
+
+	if origin := req.Header.Get("Origin"); origin != "" {
+		if !options.trustedOrigin(origin, scheme, req.Host) {
+			return fmt.Errorf("untrusted origin %v", origin)
+		}
+	} else if referer := req.Header.Get("Referer"); referer != "" {
+		u, err := url.Parse(referer)
+		if err != nil || !options.trustedOrigin(u.Scheme+"://"+u.Host, scheme, req.Host) {
+			return fmt.Errorf("untrusted referer %v", referer)
+		}
+	} else if scheme == "https" {
+		// browsers send a referer with HTTPS requests unless it is stripped, which a MITM could do
+		return errors.New("no origin or referer")
+	}
+
+	submitted := req.Header.Get(options.HeaderName)
+	if submitted == "" {
+		submitted = req.PostFormValue(options.FieldName)
+	}
+
+	if submitted == "" {
+		return errors.New("no CSRF token")
+	}
+
+	if unmasked := unmaskCSRFToken(submitted); unmasked == "" || subtle.ConstantTimeCompare([]byte(unmasked), []byte(token)) != 1 {
+		return errors.New("invalid CSRF token")
+	}
+	return nil
+}
+
+func (options CSRFOptions) trustedOrigin(origin, scheme, host string) bool {
+	if strings.EqualFold(origin, scheme+"://"+host) {
+		return true
+	}
+
+	for _, trusted := range options.TrustedOrigins {
+		if strings.EqualFold(origin, trusted) {
+			return true
+		}
+	}
+	return false
+}
+
+// safeMethod returns true for methods which shouldn't change state
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions || method == http.MethodTrace
+}
+
+// maskCSRFToken returns token XORed with a random mask, prefixed by the mask
+func maskCSRFToken(token string) string {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return ""
+	}
+
+	masked := make([]byte, 2*len(raw))
+	rand.Read(masked[:len(raw)])
+	for i, b := range raw {
+		masked[len(raw)+i] = b ^ masked[i]
+	}
+	return base64.RawURLEncoding.EncodeToString(masked)
+}
+
+// unmaskCSRFToken returns the token of a masked one, or a blank string if it is invalid
+func unmaskCSRFToken(masked string) string {
+	data, err := base64.RawURLEncoding.DecodeString(masked)
+	if err != nil || len(data) != 2*csrfTokenLength {
+		return ""
+	}
+
+	raw := make([]byte, csrfTokenLength)
+	for i := range raw {
+		raw[i] = data[i] ^ data[csrfTokenLength+i]
+	}
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func init() {
+	RegisterFactory(CSRFName, Factory{
+		Description: "protects unsafe requests from cross-site request forgery",
+		Params: []Param{
+			{Name: "mode", Type: StringParam, Default: string(CSRFDoubleSubmit), Description: "double_submit, or synchronizer to keep tokens in the session"},
+			{Name: "cookie_name", Type: StringParam},
+			{Name: "cookie_domain", Type: StringParam},
+			{Name: "cookie_path", Type: StringParam},
+			{Name: "header_name", Type: StringParam},
+			{Name: "field_name", Type: StringParam},
+			{Name: "exempt_paths", Type: StringListParam},
+			{Name: "trusted_origins", Type: StringListParam},
+			{Name: "trust_proxy", Type: BoolParam},
+		},
+		New: func(params Params) (Middleware, error) {
+			return CSRF(CSRFOptions{
+				Mode:           CSRFMode(params.String("mode")),
+				CookieName:     params.String("cookie_name"),
+				CookieDomain:   params.String("cookie_domain"),
+				CookiePath:     params.String("cookie_path"),
+				HeaderName:     params.String("header_name"),
+				FieldName:      params.String("field_name"),
+				ExemptPaths:    params.Strings("exempt_paths"),
+				TrustedOrigins: params.Strings("trusted_origins"),
+				TrustProxy:     params.Bool("trust_proxy"),
+			}), nil
+		},
+	})
+}
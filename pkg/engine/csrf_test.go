@@ -0,0 +1,148 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCSRF(t *testing.T) {
+	stack := &MiddlewareStack{}
+	stack.Use(CSRF(CSRFOptions{ExemptPaths: []string{"/webhooks/"}, TrustedOrigins: []string{"https://app.example.com"}}))
+
+	var token string
+	handler := stack.MustApply(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		token = CSRFToken(req)
+		if !strings.Contains(string(CSRFTemplateField(req)), `name="csrf_token"`) {
+			t.Errorf("Expected hidden form field, but got %v", CSRFTemplateField(req))
+		}
+	}))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "http://example.com/form", nil))
+	cookies := recorder.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != DefaultCSRFCookieName || !cookies[0].HttpOnly || token == "" {
+		t.Fatalf("Expected CSRF cookie and token, but got %v %v", cookies, token)
+	}
+
+	form := url.Values{DefaultCSRFFieldName: {token}}.Encode()
+	for name, c := range map[string]struct {
+		path, origin, header, body string
+		status                     int
+	}{
+		"header":         {"/submit", "http://example.com", token, "", http.StatusOK},
+		"form field":     {"/submit", "", "", form, http.StatusOK},
+		"trusted origin": {"/submit", "https://app.example.com", token, "", http.StatusOK},
+		"no token":       {"/submit", "http://example.com", "", "", http.StatusForbidden},
+		"wrong token":    {"/submit", "http://example.com", maskCSRFToken(strings.Repeat("A", 43)), "", http.StatusForbidden},
+		"cross origin":   {"/submit", "https://evil.com", token, "", http.StatusForbidden},
+		"exempt path":    {"/webhooks/github", "https://github.com", "", "", http.StatusOK},
+		"escaped exempt": {"/webhooks/../submit", "https://github.com", "", "", http.StatusForbidden},
+		"exempt sibling": {"/webhooks-admin/delete", "https://github.com", "", "", http.StatusForbidden},
+	} {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "http://example.com"+c.path, strings.NewReader(c.body))
+		req.AddCookie(cookies[0])
+		if c.body != "" {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		if c.origin != "" {
+			req.Header.Set("Origin", c.origin)
+		}
+		if c.header != "" {
+			req.Header.Set(DefaultCSRFHeaderName, c.header)
+		}
+		handler.ServeHTTP(recorder, req)
+
+		if recorder.Code != c.status {
+			t.Errorf("Expected %v request to get %v, but got %v", name, c.status, recorder.Code)
+		}
+	}
+
+	recorder = httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "https://example.com/submit", nil)
+	req.AddCookie(cookies[0])
+	req.Header.Set(DefaultCSRFHeaderName, token)
+	req.Header.Set("Referer", "https://evil.com/page")
+	handler.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("Expected cross origin referer to be rejected, but got %v", recorder.Code)
+	}
+}
+
+func TestCSRFMaskedTokens(t *testing.T) {
+	token := base64.RawURLEncoding.EncodeToString(bytes.Repeat([]byte{7}, csrfTokenLength))
+	first, second := maskCSRFToken(token), maskCSRFToken(token)
+
+	if first == second || unmaskCSRFToken(first) != token || unmaskCSRFToken(second) != token {
+		t.Errorf("Expected different masks of the same token, but got %v %v", first, second)
+	}
+}
+
+type testCSRFTokenStore map[string]string
+
+func (store testCSRFTokenStore) Token(req *http.Request) (string, error) {
+	return store["token"], nil
+}
+
+func (store testCSRFTokenStore) SaveToken(w http.ResponseWriter, req *http.Request, token string) error {
+	store["token"] = token
+	return nil
+}
+
+func TestCSRFSynchronizer(t *testing.T) {
+	store := testCSRFTokenStore{}
+
+	stack := &MiddlewareStack{}
+	stack.Use(CSRF(CSRFOptions{Mode: CSRFSynchronizer, TokenStore: store}))
+
+	var missingErr *MissingRequirementError
+	if _, err := stack.Compile(http.NotFoundHandler()); !errors.As(err, &missingErr) || missingErr.Requirement != SessionName {
+		t.Errorf("Expected synchronizer mode to require session, but got %v", err)
+	}
+
+	stack.Use(Middleware{Name: SessionName})
+	var token string
+	handler := stack.MustApply(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		token = CSRFToken(req)
+	}))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+	if store["token"] == "" || len(recorder.Result().Cookies()) != 0 {
+		t.Errorf("Expected token to be saved in the session only, but got %v %v", store, recorder.Result().Cookies())
+	}
+
+	recorder = httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/", nil)
+	req.Header.Set(DefaultCSRFHeaderName, token)
+	handler.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected session token to be accepted, but got %v", recorder.Code)
+	}
+}
@@ -20,22 +20,70 @@ package engine
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
 // THE SOFTWARE.
 
-import "net/http"
+import (
+	"net/http"
+
+	"google.golang.org/grpc"
+)
 
 // DefaultMiddlewareStack default middleware stack
 var DefaultMiddlewareStack = &MiddlewareStack{}
 
+// DefaultRoundTripperStack default middleware stack of outbound HTTP requests
+var DefaultRoundTripperStack = &RoundTripperStack{}
+
+// DefaultUnaryClientInterceptorStack default gRPC unary client interceptors stack
+var DefaultUnaryClientInterceptorStack = &UnaryClientInterceptorStack{}
+
+// DefaultStreamClientInterceptorStack default gRPC stream client interceptors stack
+var DefaultStreamClientInterceptorStack = &StreamClientInterceptorStack{}
+
 // Use utilizes middleware with DefaultMiddlewareStack
-func Use(middleware Middleware) {
-	DefaultMiddlewareStack.Use(middleware)
+func Use(middleware Middleware, options ...UseOption) error {
+	return DefaultMiddlewareStack.Use(middleware, options...)
 }
 
 // Remove remove middleware by name with DefaultMiddlewareStack
-func Remove(name string) {
-	DefaultMiddlewareStack.Remove(name)
+func Remove(name string) bool {
+	return DefaultMiddlewareStack.Remove(name)
 }
 
 // Apply apply DefaultMiddlewareStack's middlewares to handler
 func Apply(handler http.Handler) http.Handler {
 	return DefaultMiddlewareStack.Apply(handler)
 }
+
+// Compile apply DefaultMiddlewareStack's middlewares to handler, returns an error if it is invalid
+func Compile(handler http.Handler) (http.Handler, error) {
+	return DefaultMiddlewareStack.Compile(handler)
+}
+
+// MustApply apply DefaultMiddlewareStack's middlewares to handler, panics if it is invalid
+func MustApply(handler http.Handler) http.Handler {
+	return DefaultMiddlewareStack.MustApply(handler)
+}
+
+// ApplyLive apply DefaultMiddlewareStack's middlewares to handler, the returned handler follows its changes
+func ApplyLive(handler http.Handler) (*DynamicHandler, error) {
+	return DefaultMiddlewareStack.ApplyLive(handler)
+}
+
+// Client returns a http.Client sending requests through DefaultRoundTripperStack
+func Client() (*http.Client, error) {
+	return DefaultRoundTripperStack.Client(nil)
+}
+
+// DialOptions returns options of grpc.Dial applying the default client interceptors stacks
+func DialOptions() ([]grpc.DialOption, error) {
+	unary, err := DefaultUnaryClientInterceptorStack.DialOption()
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := DefaultStreamClientInterceptorStack.DialOption()
+	if err != nil {
+		return nil, err
+	}
+
+	return []grpc.DialOption{unary, stream}, nil
+}
@@ -24,9 +24,61 @@ import "net/http"
 
 // Middleware middleware struct
 type Middleware struct {
-	Name         string
-	Handler      func(http.Handler) http.Handler
+	Name    string
+	Handler func(http.Handler) http.Handler
+	// Group mounts another stack as a single middleware, it is used instead of
+	// Handler. The group's middlewares are sorted on their own, other
+	// middlewares refer to the whole group by Name
+	Group *MiddlewareStack
+
 	InsertAfter  []string
 	InsertBefore []string
 	Requires     []string
+
+	// OptionalAfter and OptionalBefore are soft ordering constraints, they are
+	// followed when possible, but dropped instead of failing the stack if they
+	// contradict InsertAfter, InsertBefore or each other
+	OptionalAfter  []string
+	OptionalBefore []string
+	// Conflicts lists middlewares that can't be registered together with this one
+	Conflicts []string
+
+	// Priority breaks ties between middlewares whose constraints allow either
+	// order, higher priority runs first, equal priority keeps registration order
+	Priority int
+
+	// Paths, Methods, Hosts and Match scope the middleware to some requests,
+	// the compiled handler skips it for requests not matching all of the
+	// given ones, an empty scope applies to every request. Paths are URL path
+	// prefixes matched by whole segments against the cleaned path, Hosts
+	// could start with "*." to match subdomains
+	Paths   []string
+	Methods []string
+	Hosts   []string
+	Match   func(*http.Request) bool
+
+	// Validate checks the middleware's configuration when the stack is
+	// compiled, a failing middleware fails the stack with an *InvalidMiddlewareError
+	Validate func() error
+
+	// Enabled switches the middleware on and off at runtime, e.g. by a feature
+	// flag, it is checked for every request, a middleware switched off keeps
+	// its position and still satisfies Requires of other middlewares
+	Enabled func() bool
+
+	// disabled set by MiddlewareStack.Disable
+	disabled bool
+}
+
+func (middleware *Middleware) ordering() ordering {
+	return ordering{
+		name:           middleware.Name,
+		insertBefore:   middleware.InsertBefore,
+		insertAfter:    middleware.InsertAfter,
+		optionalBefore: middleware.OptionalBefore,
+		optionalAfter:  middleware.OptionalAfter,
+		requires:       middleware.Requires,
+		conflicts:      middleware.Conflicts,
+		priority:       middleware.Priority,
+	}
 }
@@ -0,0 +1,184 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// MissingRequirementError is returned when a middleware requires another
+// middleware that is not registered in the stack
+type MissingRequirementError struct {
+	Middleware  string
+	Requirement string
+}
+
+func (err *MissingRequirementError) Error() string {
+	return fmt.Sprintf("middleware %v requires %v, but it doesn't exist", err.Middleware, err.Requirement)
+}
+
+// CycleError is returned when the ordering constraints of a stack contradict
+// each other. Path lists the middlewares forming the cycle, the first one is
+// repeated at the end, e.g. [A B C A]
+type CycleError struct {
+	Path []string
+}
+
+func (err *CycleError) Error() string {
+	return fmt.Sprintf("middlewares have cyclic ordering constraints: %v", strings.Join(err.Path, " -> "))
+}
+
+// StackError collects every error found while compiling a middleware stack
+type StackError struct {
+	Errors []error
+}
+
+func (err *StackError) Error() string {
+	var msgs []string
+	for _, e := range err.Errors {
+		msgs = append(msgs, e.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// As makes errors.As look into the collected errors, so callers could match
+// a *MissingRequirementError or *CycleError directly
+func (err *StackError) As(target interface{}) bool {
+	for _, e := range err.Errors {
+		if errors.As(e, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// newStackError wraps errs into an error, returns nil if errs is empty
+func newStackError(errs []error) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	default:
+		return &StackError{Errors: errs}
+	}
+}
+
+// OrderError is returned when a sorted stack breaks one of its declared constraints
+type OrderError struct {
+	Middleware string
+	Constraint string
+	Other      string
+}
+
+func (err *OrderError) Error() string {
+	return fmt.Sprintf("middleware %v is declared %v %v, but the sorted stack doesn't satisfy it", err.Middleware, err.Constraint, err.Other)
+}
+
+// DuplicateMiddlewareError is returned when a middleware is registered with a name already in use
+type DuplicateMiddlewareError struct {
+	Name string
+}
+
+func (err *DuplicateMiddlewareError) Error() string {
+	return fmt.Sprintf("middleware %v is already registered", err.Name)
+}
+
+// MiddlewareNotFoundError is returned when a middleware isn't registered
+type MiddlewareNotFoundError struct {
+	Name string
+}
+
+func (err *MiddlewareNotFoundError) Error() string {
+	return fmt.Sprintf("middleware %v isn't registered", err.Name)
+}
+
+// GroupError is returned when a group mounted in a stack couldn't be compiled
+type GroupError struct {
+	Group string
+	Err   error
+}
+
+func (err *GroupError) Error() string {
+	return fmt.Sprintf("group %v: %v", err.Group, err.Err)
+}
+
+// Unwrap returns the error of the group
+func (err *GroupError) Unwrap() error {
+	return err.Err
+}
+
+// ConflictError is returned when two conflicting middlewares are registered together
+type ConflictError struct {
+	Middleware string
+	Conflict   string
+}
+
+func (err *ConflictError) Error() string {
+	return fmt.Sprintf("middleware %v conflicts with %v, they can't be used together", err.Middleware, err.Conflict)
+}
+
+// ParamError is returned when a middleware parameter doesn't match its factory's schema
+type ParamError struct {
+	Param string
+	Err   error
+}
+
+func (err *ParamError) Error() string {
+	return fmt.Sprintf("param %v %v", err.Param, err.Err)
+}
+
+// Unwrap returns the error of the parameter
+func (err *ParamError) Unwrap() error {
+	return err.Err
+}
+
+// ConfigError is returned when a middleware of a stack configuration is invalid
+type ConfigError struct {
+	Middleware string
+	Err        error
+}
+
+func (err *ConfigError) Error() string {
+	return fmt.Sprintf("middleware %v: %v", err.Middleware, err.Err)
+}
+
+// Unwrap returns the error of the middleware configuration
+func (err *ConfigError) Unwrap() error {
+	return err.Err
+}
+
+// InvalidMiddlewareError is returned when a middleware's configuration is invalid
+type InvalidMiddlewareError struct {
+	Middleware string
+	Err        error
+}
+
+func (err *InvalidMiddlewareError) Error() string {
+	return fmt.Sprintf("middleware %v is invalid: %v", err.Middleware, err.Err)
+}
+
+// Unwrap returns the validation error of the middleware
+func (err *InvalidMiddlewareError) Unwrap() error {
+	return err.Err
+}
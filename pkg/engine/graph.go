@@ -0,0 +1,277 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// ordering ordering constraints of an entry in a stack, it is kept apart from
+// the registered entries, so sorting never changes what users registered
+type ordering struct {
+	name           string
+	insertBefore   []string
+	insertAfter    []string
+	optionalBefore []string
+	optionalAfter  []string
+	requires       []string
+	conflicts      []string
+	priority       int
+}
+
+func (o ordering) conflictsWith(name string) bool {
+	for _, conflict := range o.conflicts {
+		if conflict == name {
+			return true
+		}
+	}
+	return false
+}
+
+// constraintGraph directed graph of orderings, an edge from A to B means A
+// has to run before B
+type constraintGraph struct {
+	orderings  []ordering
+	indexes    map[string]int
+	successors [][]int
+	inDegrees  []int
+	edges      map[[2]int]bool
+}
+
+func newConstraintGraph(orderings []ordering) *constraintGraph {
+	graph := &constraintGraph{
+		orderings:  orderings,
+		indexes:    map[string]int{},
+		successors: make([][]int, len(orderings)),
+		inDegrees:  make([]int, len(orderings)),
+		edges:      map[[2]int]bool{},
+	}
+
+	for idx, o := range orderings {
+		graph.indexes[o.name] = idx
+	}
+
+	for idx, o := range orderings {
+		for _, insertAfter := range o.insertAfter {
+			if from, ok := graph.indexes[insertAfter]; ok {
+				graph.addEdge(from, idx)
+			}
+		}
+
+		for _, insertBefore := range o.insertBefore {
+			if to, ok := graph.indexes[insertBefore]; ok {
+				graph.addEdge(idx, to)
+			}
+		}
+	}
+
+	// optional constraints are added after all required ones, a constraint
+	// that contradicts the ones already added is dropped
+	for idx, o := range orderings {
+		for _, optionalAfter := range o.optionalAfter {
+			if from, ok := graph.indexes[optionalAfter]; ok && !graph.reachable(idx, from) {
+				graph.addEdge(from, idx)
+			}
+		}
+
+		for _, optionalBefore := range o.optionalBefore {
+			if to, ok := graph.indexes[optionalBefore]; ok && !graph.reachable(to, idx) {
+				graph.addEdge(idx, to)
+			}
+		}
+	}
+
+	return graph
+}
+
+// reachable returns true if to has to run after from
+func (graph *constraintGraph) reachable(from, to int) bool {
+	var (
+		visited = make([]bool, len(graph.orderings))
+		stack   = []int{from}
+	)
+
+	for len(stack) > 0 {
+		idx := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if idx == to {
+			return true
+		}
+
+		if !visited[idx] {
+			visited[idx] = true
+			stack = append(stack, graph.successors[idx]...)
+		}
+	}
+	return false
+}
+
+func (graph *constraintGraph) addEdge(from, to int) {
+	if !graph.edges[[2]int{from, to}] {
+		graph.edges[[2]int{from, to}] = true
+		graph.successors[from] = append(graph.successors[from], to)
+		graph.inDegrees[to]++
+	}
+}
+
+// sortOrderings sort orderings with Kahn's algorithm and returns their indexes
+// in running order. An entry is picked once every entry it has to run after is
+// sorted, when several entries are ready, the one with the highest priority
+// wins, then the one registered first, so the same entries always give the
+// same order
+func sortOrderings(orderings []ordering) ([]int, error) {
+	var (
+		errs      []error
+		sorted    []int
+		graph     = newConstraintGraph(orderings)
+		inDegrees = append([]int{}, graph.inDegrees...)
+		done      = make([]bool, len(orderings))
+	)
+
+	for idx, o := range orderings {
+		for _, require := range o.requires {
+			if _, ok := graph.indexes[require]; !ok {
+				errs = append(errs, &MissingRequirementError{Middleware: o.name, Requirement: require})
+			}
+		}
+
+		for _, conflict := range o.conflicts {
+			// report each conflicting pair once, even if both declare it
+			if other, ok := graph.indexes[conflict]; ok && other != idx && !(other < idx && orderings[other].conflictsWith(o.name)) {
+				errs = append(errs, &ConflictError{Middleware: o.name, Conflict: conflict})
+			}
+		}
+	}
+
+	for len(sorted) < len(orderings) {
+		next := -1
+		for idx, o := range orderings {
+			if !done[idx] && inDegrees[idx] == 0 && (next < 0 || o.priority > orderings[next].priority) {
+				next = idx
+			}
+		}
+
+		if next < 0 {
+			// every pending entry waits for another pending one
+			errs = append(errs, graph.findCycle(done))
+			break
+		}
+
+		done[next] = true
+		sorted = append(sorted, next)
+		for _, successor := range graph.successors[next] {
+			inDegrees[successor]--
+		}
+	}
+
+	if len(sorted) == len(orderings) {
+		if err := verifyOrder(orderings, sorted); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := newStackError(errs); err != nil {
+		return nil, err
+	}
+
+	return sorted, nil
+}
+
+// findCycle returns a cycle among the entries that are not done
+func (graph *constraintGraph) findCycle(done []bool) *CycleError {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	var (
+		states = make([]int, len(graph.orderings))
+		path   []int
+		visit  func(idx int) *CycleError
+	)
+
+	visit = func(idx int) *CycleError {
+		switch states[idx] {
+		case visiting:
+			cycle := &CycleError{}
+			for i := len(path) - 1; i >= 0; i-- {
+				if path[i] == idx {
+					for _, p := range path[i:] {
+						cycle.Path = append(cycle.Path, graph.orderings[p].name)
+					}
+					break
+				}
+			}
+			cycle.Path = append(cycle.Path, graph.orderings[idx].name)
+			return cycle
+		case visited:
+			return nil
+		}
+
+		states[idx] = visiting
+		path = append(path, idx)
+		for _, successor := range graph.successors[idx] {
+			if !done[successor] {
+				if cycle := visit(successor); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		states[idx] = visited
+		return nil
+	}
+
+	for idx := range graph.orderings {
+		if !done[idx] {
+			if cycle := visit(idx); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+// verifyOrder checks every declared InsertBefore/InsertAfter constraint holds in sorted
+func verifyOrder(orderings []ordering, sorted []int) error {
+	var (
+		errs      []error
+		positions = map[string]int{}
+	)
+
+	for pos, idx := range sorted {
+		positions[orderings[idx].name] = pos
+	}
+
+	for pos, idx := range sorted {
+		o := orderings[idx]
+		for _, insertAfter := range o.insertAfter {
+			if p, ok := positions[insertAfter]; ok && p > pos {
+				errs = append(errs, &OrderError{Middleware: o.name, Constraint: "InsertAfter", Other: insertAfter})
+			}
+		}
+
+		for _, insertBefore := range o.insertBefore {
+			if p, ok := positions[insertBefore]; ok && p < pos {
+				errs = append(errs, &OrderError{Middleware: o.name, Constraint: "InsertBefore", Other: insertBefore})
+			}
+		}
+	}
+
+	return newStackError(errs)
+}
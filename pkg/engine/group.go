@@ -0,0 +1,134 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"net/http"
+)
+
+// compilation state of compiling a stack together with its mounted groups
+type compilation struct {
+	groups  []*MiddlewareStack
+	names   []string
+	sources []source
+}
+
+// source snapshot of a stack used by a compiled chain
+type source struct {
+	stack    *MiddlewareStack
+	snapshot *stackSnapshot
+}
+
+// compileStack apply middlewares of stack and its groups to handler, returns
+// the snapshots it is compiled from, even if it failed
+func compileStack(stack *MiddlewareStack, handler http.Handler) (http.Handler, []source, error) {
+	c := &compilation{groups: []*MiddlewareStack{stack}, names: []string{""}}
+	compiledHandler, err := c.compile(stack, handler)
+	return compiledHandler, c.sources, err
+}
+
+func (c *compilation) compile(stack *MiddlewareStack, handler http.Handler) (http.Handler, error) {
+	var (
+		errs     []error
+		snapshot = stack.snapshots.load()
+	)
+
+	c.sources = append(c.sources, source{stack: stack, snapshot: snapshot})
+
+	sortedMiddlewares, err := snapshot.sortMiddlewares()
+	if err != nil {
+		return nil, err
+	}
+
+	compiledHandler := handler
+	for idx := len(sortedMiddlewares) - 1; idx >= 0; idx-- {
+		middleware := sortedMiddlewares[idx]
+		wrappedHandler, err := c.wrap(middleware, compiledHandler)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		compiledHandler = middleware.scope(compiledHandler, wrappedHandler)
+	}
+
+	if err := newStackError(errs); err != nil {
+		return nil, err
+	}
+	return compiledHandler, nil
+}
+
+// wrap wraps next with the middleware's handler, or its compiled group
+func (c *compilation) wrap(middleware *Middleware, next http.Handler) (http.Handler, error) {
+	if middleware.disabled {
+		return next, nil
+	}
+
+	if middleware.Validate != nil {
+		if err := middleware.Validate(); err != nil {
+			return nil, &InvalidMiddlewareError{Middleware: middleware.Name, Err: err}
+		}
+	}
+
+	if middleware.Group == nil {
+		if middleware.Handler == nil {
+			// middleware only used as an anchor for ordering constraints
+			return next, nil
+		}
+		return middleware.Handler(next), nil
+	}
+
+	if idx := indexOfStack(c.groups, middleware.Group); idx >= 0 {
+		cycle := &CycleError{}
+		for _, name := range c.names[idx:] {
+			if name != "" {
+				cycle.Path = append(cycle.Path, name)
+			}
+		}
+		cycle.Path = append(cycle.Path, middleware.Name)
+		return nil, &GroupError{Group: middleware.Name, Err: cycle}
+	}
+
+	c.groups = append(c.groups, middleware.Group)
+	c.names = append(c.names, middleware.Name)
+	defer func() {
+		c.groups = c.groups[:len(c.groups)-1]
+		c.names = c.names[:len(c.names)-1]
+	}()
+
+	groupHandler, err := c.compile(middleware.Group, next)
+	if err != nil {
+		return nil, &GroupError{Group: middleware.Name, Err: err}
+	}
+	return groupHandler, nil
+}
+
+func indexOfStack(stacks []*MiddlewareStack, stack *MiddlewareStack) int {
+	for idx, s := range stacks {
+		if s == stack {
+			return idx
+		}
+	}
+	return -1
+}
+
+func hasStack(stacks []*MiddlewareStack, stack *MiddlewareStack) bool {
+	return indexOfStack(stacks, stack) >= 0
+}
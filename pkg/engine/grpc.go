@@ -0,0 +1,209 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"google.golang.org/grpc"
+)
+
+// UnaryInterceptor gRPC unary server interceptor, it is ordered like Middleware
+type UnaryInterceptor struct {
+	Ordering
+	Interceptor grpc.UnaryServerInterceptor
+}
+
+// StreamInterceptor gRPC stream server interceptor, it is ordered like Middleware
+type StreamInterceptor struct {
+	Ordering
+	Interceptor grpc.StreamServerInterceptor
+}
+
+// UnaryInterceptorStack gRPC unary server interceptors stack, interceptors are
+// sorted with the same rules as MiddlewareStack, the first one is outermost
+type UnaryInterceptorStack struct {
+	orderedList
+}
+
+// Use use interceptor, returns a *DuplicateMiddlewareError if its name is taken, unless ReplaceExisting is given
+func (stack *UnaryInterceptorStack) Use(interceptor UnaryInterceptor, options ...UseOption) error {
+	return stack.use(interceptor.ordering(), interceptor.Interceptor, options)
+}
+
+// Interceptors returns sorted interceptors, or an error if the stack's constraints couldn't be satisfied
+func (stack *UnaryInterceptorStack) Interceptors() ([]grpc.UnaryServerInterceptor, error) {
+	sortedEntries, err := stack.sorted()
+	if err != nil {
+		return nil, err
+	}
+
+	var interceptors []grpc.UnaryServerInterceptor
+	for _, entry := range sortedEntries {
+		if interceptor := entry.value.(grpc.UnaryServerInterceptor); interceptor != nil {
+			interceptors = append(interceptors, interceptor)
+		}
+	}
+	return interceptors, nil
+}
+
+// ServerOption returns the sorted interceptors as an option of grpc.NewServer
+func (stack *UnaryInterceptorStack) ServerOption() (grpc.ServerOption, error) {
+	interceptors, err := stack.Interceptors()
+	if err != nil {
+		return nil, err
+	}
+	return grpc.ChainUnaryInterceptor(interceptors...), nil
+}
+
+func (stack *UnaryInterceptorStack) String() string {
+	return stack.describe("UnaryInterceptorStack")
+}
+
+// StreamInterceptorStack gRPC stream server interceptors stack, interceptors are
+// sorted with the same rules as MiddlewareStack, the first one is outermost
+type StreamInterceptorStack struct {
+	orderedList
+}
+
+// Use use interceptor, returns a *DuplicateMiddlewareError if its name is taken, unless ReplaceExisting is given
+func (stack *StreamInterceptorStack) Use(interceptor StreamInterceptor, options ...UseOption) error {
+	return stack.use(interceptor.ordering(), interceptor.Interceptor, options)
+}
+
+// Interceptors returns sorted interceptors, or an error if the stack's constraints couldn't be satisfied
+func (stack *StreamInterceptorStack) Interceptors() ([]grpc.StreamServerInterceptor, error) {
+	sortedEntries, err := stack.sorted()
+	if err != nil {
+		return nil, err
+	}
+
+	var interceptors []grpc.StreamServerInterceptor
+	for _, entry := range sortedEntries {
+		if interceptor := entry.value.(grpc.StreamServerInterceptor); interceptor != nil {
+			interceptors = append(interceptors, interceptor)
+		}
+	}
+	return interceptors, nil
+}
+
+// ServerOption returns the sorted interceptors as an option of grpc.NewServer
+func (stack *StreamInterceptorStack) ServerOption() (grpc.ServerOption, error) {
+	interceptors, err := stack.Interceptors()
+	if err != nil {
+		return nil, err
+	}
+	return grpc.ChainStreamInterceptor(interceptors...), nil
+}
+
+func (stack *StreamInterceptorStack) String() string {
+	return stack.describe("StreamInterceptorStack")
+}
+
+// UnaryClientInterceptor gRPC unary client interceptor, it is ordered like Middleware
+type UnaryClientInterceptor struct {
+	Ordering
+	Interceptor grpc.UnaryClientInterceptor
+}
+
+// StreamClientInterceptor gRPC stream client interceptor, it is ordered like Middleware
+type StreamClientInterceptor struct {
+	Ordering
+	Interceptor grpc.StreamClientInterceptor
+}
+
+// UnaryClientInterceptorStack gRPC unary client interceptors stack, interceptors
+// are sorted with the same rules as MiddlewareStack, the first one is outermost
+type UnaryClientInterceptorStack struct {
+	orderedList
+}
+
+// Use use interceptor, returns a *DuplicateMiddlewareError if its name is taken, unless ReplaceExisting is given
+func (stack *UnaryClientInterceptorStack) Use(interceptor UnaryClientInterceptor, options ...UseOption) error {
+	return stack.use(interceptor.ordering(), interceptor.Interceptor, options)
+}
+
+// Interceptors returns sorted interceptors, or an error if the stack's constraints couldn't be satisfied
+func (stack *UnaryClientInterceptorStack) Interceptors() ([]grpc.UnaryClientInterceptor, error) {
+	sortedEntries, err := stack.sorted()
+	if err != nil {
+		return nil, err
+	}
+
+	var interceptors []grpc.UnaryClientInterceptor
+	for _, entry := range sortedEntries {
+		if interceptor := entry.value.(grpc.UnaryClientInterceptor); interceptor != nil {
+			interceptors = append(interceptors, interceptor)
+		}
+	}
+	return interceptors, nil
+}
+
+// DialOption returns the sorted interceptors as an option of grpc.Dial
+func (stack *UnaryClientInterceptorStack) DialOption() (grpc.DialOption, error) {
+	interceptors, err := stack.Interceptors()
+	if err != nil {
+		return nil, err
+	}
+	return grpc.WithChainUnaryInterceptor(interceptors...), nil
+}
+
+func (stack *UnaryClientInterceptorStack) String() string {
+	return stack.describe("UnaryClientInterceptorStack")
+}
+
+// StreamClientInterceptorStack gRPC stream client interceptors stack, interceptors
+// are sorted with the same rules as MiddlewareStack, the first one is outermost
+type StreamClientInterceptorStack struct {
+	orderedList
+}
+
+// Use use interceptor, returns a *DuplicateMiddlewareError if its name is taken, unless ReplaceExisting is given
+func (stack *StreamClientInterceptorStack) Use(interceptor StreamClientInterceptor, options ...UseOption) error {
+	return stack.use(interceptor.ordering(), interceptor.Interceptor, options)
+}
+
+// Interceptors returns sorted interceptors, or an error if the stack's constraints couldn't be satisfied
+func (stack *StreamClientInterceptorStack) Interceptors() ([]grpc.StreamClientInterceptor, error) {
+	sortedEntries, err := stack.sorted()
+	if err != nil {
+		return nil, err
+	}
+
+	var interceptors []grpc.StreamClientInterceptor
+	for _, entry := range sortedEntries {
+		if interceptor := entry.value.(grpc.StreamClientInterceptor); interceptor != nil {
+			interceptors = append(interceptors, interceptor)
+		}
+	}
+	return interceptors, nil
+}
+
+// DialOption returns the sorted interceptors as an option of grpc.Dial
+func (stack *StreamClientInterceptorStack) DialOption() (grpc.DialOption, error) {
+	interceptors, err := stack.Interceptors()
+	if err != nil {
+		return nil, err
+	}
+	return grpc.WithChainStreamInterceptor(interceptors...), nil
+}
+
+func (stack *StreamClientInterceptorStack) String() string {
+	return stack.describe("StreamClientInterceptorStack")
+}
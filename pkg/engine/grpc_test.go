@@ -0,0 +1,89 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"google.golang.org/grpc"
+)
+
+func TestUnaryInterceptorStack(t *testing.T) {
+	var (
+		called []string
+		stack  = &UnaryInterceptorStack{}
+		record = func(name string) grpc.UnaryServerInterceptor {
+			return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+				called = append(called, name)
+				return handler(ctx, req)
+			}
+		}
+	)
+
+	stack.Use(UnaryInterceptor{Ordering: Ordering{Name: "auth", InsertAfter: []string{"logging"}, Requires: []string{"logging"}}, Interceptor: record("auth")})
+	stack.Use(UnaryInterceptor{Ordering: Ordering{Name: "recover", InsertBefore: []string{"logging"}}, Interceptor: record("recover")})
+	stack.Use(UnaryInterceptor{Ordering: Ordering{Name: "logging"}, Interceptor: record("logging")})
+
+	if str := stack.String(); str != "UnaryInterceptorStack: recover, logging, auth" {
+		t.Errorf("Expected interceptors recover, logging, auth, but got %v", str)
+	}
+
+	interceptors, err := stack.Interceptors()
+	if err != nil {
+		t.Fatalf("Failed to sort interceptors, got %v", err)
+	}
+
+	for _, interceptor := range interceptors {
+		interceptor(context.Background(), nil, nil, func(context.Context, interface{}) (interface{}, error) { return nil, nil })
+	}
+	if fmt.Sprint(called) != "[recover logging auth]" {
+		t.Errorf("Expected interceptors called in order recover, logging, auth, but got %v", called)
+	}
+
+	if _, err := stack.ServerOption(); err != nil {
+		t.Errorf("Failed to build server option, got %v", err)
+	}
+
+	stack.Remove("logging")
+	var missing *MissingRequirementError
+	if _, err := stack.ServerOption(); !errors.As(err, &missing) || missing.Middleware != "auth" {
+		t.Errorf("Should return missing requirement error, but got %v", err)
+	}
+}
+
+func TestStreamInterceptorStackCycle(t *testing.T) {
+	stack := &StreamInterceptorStack{}
+	stack.Use(StreamInterceptor{Ordering: Ordering{Name: "A", InsertBefore: []string{"B"}}})
+	stack.Use(StreamInterceptor{Ordering: Ordering{Name: "B", InsertBefore: []string{"A"}}})
+
+	var cycle *CycleError
+	if _, err := stack.ServerOption(); !errors.As(err, &cycle) {
+		t.Errorf("Should return cycle error, but got %v", err)
+	}
+
+	var duplicate *DuplicateMiddlewareError
+	if err := stack.Use(StreamInterceptor{Ordering: Ordering{Name: "A"}}); !errors.As(err, &duplicate) {
+		t.Errorf("Should return duplicate error, but got %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// DynamicHandler http.Handler that follows changes of a MiddlewareStack, the
+// chain is rebuilt on the first request after the stack changed, and swapped
+// in atomically, requests already being served finish on the previous chain
+type DynamicHandler struct {
+	stack   *MiddlewareStack
+	handler http.Handler
+
+	mu    sync.Mutex
+	chain atomic.Value
+}
+
+// liveChain compiled chain of a DynamicHandler and the snapshots of the stack
+// and its groups it is built from
+type liveChain struct {
+	sources []source
+	handler http.Handler
+	err     error
+}
+
+// stale returns true if the stack or one of its groups changed since the chain is built
+func (chain *liveChain) stale() bool {
+	for _, source := range chain.sources {
+		if source.stack.snapshots.load() != source.snapshot {
+			return true
+		}
+	}
+	return false
+}
+
+// ApplyLive apply middlewares to handler, the returned handler picks up later
+// changes of the stack. It returns an error if the stack couldn't be compiled
+func (stack *MiddlewareStack) ApplyLive(handler http.Handler) (*DynamicHandler, error) {
+	compiledHandler, sources, err := compileStack(stack, handler)
+	if err != nil {
+		return nil, err
+	}
+
+	dynamicHandler := &DynamicHandler{stack: stack, handler: handler}
+	dynamicHandler.chain.Store(&liveChain{sources: sources, handler: compiledHandler})
+	return dynamicHandler, nil
+}
+
+// ServeHTTP serve request with the latest compiled chain
+func (dynamicHandler *DynamicHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	dynamicHandler.current().handler.ServeHTTP(w, req)
+}
+
+// Err returns the error of the last rebuild, if the stack was changed to an
+// invalid one, the handler keeps serving the last valid chain
+func (dynamicHandler *DynamicHandler) Err() error {
+	return dynamicHandler.current().err
+}
+
+func (dynamicHandler *DynamicHandler) current() *liveChain {
+	chain := dynamicHandler.chain.Load().(*liveChain)
+	if !chain.stale() {
+		return chain
+	}
+	return dynamicHandler.rebuild()
+}
+
+// rebuild compiles the current snapshots of the stack, concurrent requests
+// wait for a single rebuild instead of compiling the same snapshots
+func (dynamicHandler *DynamicHandler) rebuild() *liveChain {
+	dynamicHandler.mu.Lock()
+	defer dynamicHandler.mu.Unlock()
+
+	chain := dynamicHandler.chain.Load().(*liveChain)
+	if !chain.stale() {
+		return chain
+	}
+
+	compiledHandler, sources, err := compileStack(dynamicHandler.stack, dynamicHandler.handler)
+	if err != nil {
+		// keep serving the last valid chain, until the stack changes again
+		chain = &liveChain{sources: sources, handler: chain.handler, err: err}
+	} else {
+		chain = &liveChain{sources: sources, handler: compiledHandler}
+	}
+
+	dynamicHandler.chain.Store(chain)
+	return chain
+}
@@ -0,0 +1,138 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func headerMiddleware(name string) Middleware {
+	return Middleware{
+		Name: name,
+		Handler: func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				w.Header().Add("X-Middleware", name)
+				next.ServeHTTP(w, req)
+			})
+		},
+	}
+}
+
+func serveLive(handler http.Handler) []string {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+	return recorder.Header()["X-Middleware"]
+}
+
+func TestApplyLiveFollowsStackChanges(t *testing.T) {
+	stack := &MiddlewareStack{}
+	stack.Use(headerMiddleware("cookie"))
+
+	handler, err := stack.ApplyLive(http.NotFoundHandler())
+	if err != nil {
+		t.Fatalf("Failed to apply middlewares, got %v", err)
+	}
+
+	if names := serveLive(handler); len(names) != 1 || names[0] != "cookie" {
+		t.Errorf("Expected cookie middleware to be applied, but got %v", names)
+	}
+
+	stack.Use(headerMiddleware("debug"))
+	if names := serveLive(handler); len(names) != 2 || names[1] != "debug" {
+		t.Errorf("Expected debug middleware to be picked up, but got %v", names)
+	}
+
+	stack.Remove("debug")
+	if names := serveLive(handler); len(names) != 1 || names[0] != "cookie" {
+		t.Errorf("Expected debug middleware to be dropped, but got %v", names)
+	}
+}
+
+func TestApplyLiveKeepsLastValidChain(t *testing.T) {
+	stack := &MiddlewareStack{}
+	stack.Use(headerMiddleware("cookie"))
+
+	handler, err := stack.ApplyLive(http.NotFoundHandler())
+	if err != nil {
+		t.Fatalf("Failed to apply middlewares, got %v", err)
+	}
+
+	flash := headerMiddleware("flash")
+	flash.Requires = []string{"session"}
+	stack.Use(flash)
+
+	if names := serveLive(handler); len(names) != 1 || names[0] != "cookie" {
+		t.Errorf("Expected last valid chain to be served, but got %v", names)
+	}
+
+	if handler.Err() == nil {
+		t.Errorf("Should report error as required middleware doesn't exist")
+	}
+}
+
+func TestDisableMiddleware(t *testing.T) {
+	var dumping bool
+	dumper := headerMiddleware("dumper")
+	dumper.Enabled = func() bool { return dumping }
+	dumper.InsertAfter = []string{"cookie"}
+
+	stack := &MiddlewareStack{}
+	stack.Use(headerMiddleware("cookie"))
+	stack.Use(dumper)
+	stack.Use(Middleware{Name: "session", Handler: headerMiddleware("session").Handler, InsertBefore: []string{"cookie"}})
+
+	handler, err := stack.ApplyLive(http.NotFoundHandler())
+	if err != nil {
+		t.Fatalf("Failed to apply middlewares, got %v", err)
+	}
+
+	if names := serveLive(handler); len(names) != 2 || names[0] != "session" {
+		t.Errorf("Expected dumper to be switched off, but got %v", names)
+	}
+
+	dumping = true
+	if names := serveLive(handler); len(names) != 3 || names[2] != "dumper" {
+		t.Errorf("Expected dumper to be switched on, but got %v", names)
+	}
+
+	if err := stack.Disable("session"); err != nil {
+		t.Errorf("Failed to disable session, got %v", err)
+	}
+
+	if stack.String() != "MiddlewareStack: session (disabled), cookie, dumper" {
+		t.Errorf("Disabled middleware should keep its position, but got %v", stack.String())
+	}
+
+	if names := serveLive(handler); len(names) != 2 || names[0] != "cookie" {
+		t.Errorf("Expected session to be skipped, but got %v", names)
+	}
+
+	stack.Enable("session")
+	if names := serveLive(handler); len(names) != 3 || names[0] != "session" {
+		t.Errorf("Expected session to be enabled again, but got %v", names)
+	}
+
+	if err := stack.Disable("missing"); err == nil {
+		t.Errorf("Should return error when disabling unregistered middleware")
+	}
+}
@@ -26,159 +26,217 @@ import (
 	"strings"
 )
 
-// MiddlewareStack middlewares stack
+// MiddlewareStack middlewares stack, it is safe for concurrent use, changes
+// are published as new snapshots, so a compiling stack never sees a half
+// updated one. A MiddlewareStack must not be copied after first use
 type MiddlewareStack struct {
-	middlewares []*Middleware
+	snapshots snapshots
 }
 
-// Use use middleware
-func (stack *MiddlewareStack) Use(middleware Middleware) {
-	stack.middlewares = append(stack.middlewares, &middleware)
-}
+// UseOption option of MiddlewareStack.Use
+type UseOption func(*useOptions)
 
-// Remove remove middleware by name
-func (stack *MiddlewareStack) Remove(name string) {
-	registeredMiddlewares := stack.middlewares
-	for idx, middleware := range registeredMiddlewares {
-		if middleware.Name == name {
-			if idx > 0 {
-				stack.middlewares = stack.middlewares[0 : idx-1]
-			} else {
-				stack.middlewares = []*Middleware{}
-			}
+type useOptions struct {
+	replace bool
+}
 
-			if idx < len(registeredMiddlewares)-1 {
-				stack.middlewares = append(stack.middlewares, registeredMiddlewares[idx+1:]...)
-			}
-		}
+// ReplaceExisting makes Use replace a registered middleware with the same
+// name in place, instead of returning a *DuplicateMiddlewareError
+func ReplaceExisting() UseOption {
+	return func(options *useOptions) {
+		options.replace = true
 	}
 }
 
-// sortMiddlewares sort middlewares
-func (stack *MiddlewareStack) sortMiddlewares() (sortedMiddlewares []*Middleware, err error) {
-	var (
-		errs                         []error
-		middlewareNames, sortedNames []string
-		middlewaresMap               = map[string]*Middleware{}
-		sortMiddleware               func(m *Middleware)
-	)
-
-	for _, middleware := range stack.middlewares {
-		middlewaresMap[middleware.Name] = middleware
-		middlewareNames = append(middlewareNames, middleware.Name)
+// Use use middleware, returns a *DuplicateMiddlewareError if a middleware
+// with the same name is registered, unless ReplaceExisting is given
+func (stack *MiddlewareStack) Use(middleware Middleware, options ...UseOption) error {
+	var opts useOptions
+	for _, option := range options {
+		option(&opts)
 	}
 
-	for _, middleware := range stack.middlewares {
-		for _, require := range middleware.Requires {
-			if _, ok := middlewaresMap[require]; !ok {
-				errs = append(errs, fmt.Errorf("middleware %v requires %v, but it doesn't exist", middleware.Name, require))
+	return stack.snapshots.update(func(middlewares []*Middleware) ([]*Middleware, error) {
+		if idx := indexOf(middlewares, middleware.Name); idx >= 0 {
+			if !opts.replace {
+				return nil, &DuplicateMiddlewareError{Name: middleware.Name}
 			}
+			middleware.disabled = middlewares[idx].disabled
+			middlewares[idx] = &middleware
+			return middlewares, nil
 		}
+		return append(middlewares, &middleware), nil
+	})
+}
 
-		for _, insertBefore := range middleware.InsertBefore {
-			if m, ok := middlewaresMap[insertBefore]; ok {
-				m.InsertAfter = uniqueAppend(m.InsertAfter, middleware.Name)
+// Remove remove middleware by name, returns false if it isn't registered
+func (stack *MiddlewareStack) Remove(name string) bool {
+	err := stack.snapshots.update(func(registeredMiddlewares []*Middleware) ([]*Middleware, error) {
+		var middlewares []*Middleware
+		for _, middleware := range registeredMiddlewares {
+			if middleware.Name != name {
+				middlewares = append(middlewares, middleware)
 			}
 		}
 
-		for _, insertAfter := range middleware.InsertAfter {
-			if m, ok := middlewaresMap[insertAfter]; ok {
-				m.InsertBefore = uniqueAppend(m.InsertBefore, middleware.Name)
-			}
+		if len(middlewares) == len(registeredMiddlewares) {
+			return nil, &MiddlewareNotFoundError{Name: name}
 		}
-	}
+		return middlewares, nil
+	})
+	return err == nil
+}
 
-	if len(errs) > 0 {
-		return nil, fmt.Errorf("%v", errs)
-	}
+// Replace replace middleware registered as name with middleware, it keeps the
+// registration position and whether it is disabled. Returns a *MiddlewareNotFoundError if name isn't
+// registered, or a *DuplicateMiddlewareError if middleware is renamed to the
+// name of another registered middleware
+func (stack *MiddlewareStack) Replace(name string, middleware Middleware) error {
+	return stack.snapshots.update(func(middlewares []*Middleware) ([]*Middleware, error) {
+		idx := indexOf(middlewares, name)
+		if idx < 0 {
+			return nil, &MiddlewareNotFoundError{Name: name}
+		}
 
-	sortMiddleware = func(m *Middleware) {
-		if _, found := getRIndex(sortedNames, m.Name); !found { // if not sorted
-			var minIndex = -1
-
-			// sort by InsertAfter
-			for _, insertAfter := range m.InsertAfter {
-				idx, found := getRIndex(sortedNames, insertAfter)
-				if !found {
-					if middleware, ok := middlewaresMap[insertAfter]; ok {
-						sortMiddleware(middleware)
-						idx, found = getRIndex(sortedNames, insertAfter)
-					}
-				}
-
-				if found && idx > minIndex {
-					minIndex = idx
-				}
-			}
+		if existing := indexOf(middlewares, middleware.Name); existing >= 0 && existing != idx {
+			return nil, &DuplicateMiddlewareError{Name: middleware.Name}
+		}
 
-			// sort by InsertBefore
-			for _, insertBefore := range m.InsertBefore {
-				if idx, found := getRIndex(sortedNames, insertBefore); found {
-					if idx < minIndex {
-						sortedNames = append(sortedNames[:idx], sortedNames[idx+1:]...)
-						sortMiddleware(middlewaresMap[insertBefore])
-						return
-					}
-				}
-			}
+		middleware.disabled = middlewares[idx].disabled
+		middlewares[idx] = &middleware
+		return middlewares, nil
+	})
+}
 
-			if minIndex >= 0 {
-				sortedNames = append(sortedNames[:minIndex+1], append([]string{m.Name}, sortedNames[minIndex+1:]...)...)
-			} else if _, has := getRIndex(sortedNames, m.Name); !has {
-				// if current callback haven't been sorted, append it to last
-				sortedNames = append(sortedNames, m.Name)
-			}
+// Disable disable middleware name, it stays registered with its ordering
+// constraints, but is skipped in compiled handlers until it is enabled again
+func (stack *MiddlewareStack) Disable(name string) error {
+	return stack.setDisabled(name, true)
+}
+
+// Enable enable middleware name disabled by Disable
+func (stack *MiddlewareStack) Enable(name string) error {
+	return stack.setDisabled(name, false)
+}
+
+func (stack *MiddlewareStack) setDisabled(name string, disabled bool) error {
+	return stack.snapshots.update(func(middlewares []*Middleware) ([]*Middleware, error) {
+		idx := indexOf(middlewares, name)
+		if idx < 0 {
+			return nil, &MiddlewareNotFoundError{Name: name}
 		}
+
+		middleware := *middlewares[idx]
+		middleware.disabled = disabled
+		middlewares[idx] = &middleware
+		return middlewares, nil
+	})
+}
+
+// Has returns true if middleware name is registered
+func (stack *MiddlewareStack) Has(name string) bool {
+	return indexOf(stack.snapshots.load().middlewares, name) >= 0
+}
+
+// Get returns a copy of the middleware registered as name
+func (stack *MiddlewareStack) Get(name string) (Middleware, bool) {
+	middlewares := stack.snapshots.load().middlewares
+	if idx := indexOf(middlewares, name); idx >= 0 {
+		return *middlewares[idx], true
 	}
+	return Middleware{}, false
+}
 
-	for _, middleware := range stack.middlewares {
-		sortMiddleware(middleware)
+// Names returns names of registered middlewares in registration order
+func (stack *MiddlewareStack) Names() []string {
+	var names []string
+	for _, middleware := range stack.snapshots.load().middlewares {
+		names = append(names, middleware.Name)
 	}
+	return names
+}
+
+// Len returns count of registered middlewares
+func (stack *MiddlewareStack) Len() int {
+	return len(stack.snapshots.load().middlewares)
+}
 
-	for _, name := range sortedNames {
-		sortedMiddlewares = append(sortedMiddlewares, middlewaresMap[name])
+// swap replaces all middlewares of the stack with the ones of other at once
+func (stack *MiddlewareStack) swap(other *MiddlewareStack) {
+	stack.snapshots.update(func([]*Middleware) ([]*Middleware, error) {
+		return other.snapshots.load().middlewares, nil
+	})
+}
+
+// indexOf returns index of middleware name, or -1 if it isn't registered
+func indexOf(middlewares []*Middleware, name string) int {
+	for idx, middleware := range middlewares {
+		if middleware.Name == name {
+			return idx
+		}
 	}
+	return -1
+}
 
-	return sortedMiddlewares, nil
+// sortMiddlewares sort middlewares of the current snapshot by their
+// constraints, registered middlewares are left untouched, see sortOrderings
+// for how ties are broken
+func (stack *MiddlewareStack) sortMiddlewares() (sortedMiddlewares []*Middleware, err error) {
+	return stack.snapshots.load().sortMiddlewares()
 }
 
+// String returns the sorted middlewares, mounted groups are shown with their
+// own middlewares, e.g. "MiddlewareStack: request_id, security[auth, csrf]"
 func (stack *MiddlewareStack) String() string {
-	var (
-		sortedNames            []string
-		sortedMiddlewares, err = stack.sortMiddlewares()
-	)
+	return fmt.Sprintf("MiddlewareStack: %v", describeStack(stack, nil))
+}
 
+func describeStack(stack *MiddlewareStack, parents []*MiddlewareStack) string {
+	sortedMiddlewares, err := stack.sortMiddlewares()
 	if err != nil {
-		fmt.Println(err)
+		return fmt.Sprintf("<invalid: %v>", err)
 	}
 
+	var (
+		sortedNames []string
+		groups      = append(parents, stack)
+	)
+
 	for _, middleware := range sortedMiddlewares {
-		sortedNames = append(sortedNames, middleware.Name)
+		switch {
+		case middleware.disabled:
+			sortedNames = append(sortedNames, fmt.Sprintf("%v (disabled)", middleware.Name))
+		case middleware.Group == nil:
+			sortedNames = append(sortedNames, middleware.Name)
+		case hasStack(groups, middleware.Group):
+			sortedNames = append(sortedNames, fmt.Sprintf("%v[...]", middleware.Name))
+		default:
+			sortedNames = append(sortedNames, fmt.Sprintf("%v[%v]", middleware.Name, describeStack(middleware.Group, groups)))
+		}
 	}
 
-	return fmt.Sprintf("MiddlewareStack: %v", strings.Join(sortedNames, ", "))
+	return strings.Join(sortedNames, ", ")
 }
 
-// Apply apply middlewares to handler
-func (stack *MiddlewareStack) Apply(handler http.Handler) http.Handler {
-	var (
-		compiledHandler        http.Handler
-		sortedMiddlewares, err = stack.sortMiddlewares()
-	)
+// Compile apply middlewares to handler, returns an error if the stack's
+// constraints could not be satisfied, e.g. a *MissingRequirementError or a
+// *CycleError, several errors are reported together as a *StackError
+func (stack *MiddlewareStack) Compile(handler http.Handler) (http.Handler, error) {
+	compiledHandler, _, err := compileStack(stack, handler)
+	return compiledHandler, err
+}
 
+// MustApply apply middlewares to handler, panics if the stack could not be compiled
+func (stack *MiddlewareStack) MustApply(handler http.Handler) http.Handler {
+	compiledHandler, err := stack.Compile(handler)
 	if err != nil {
-		fmt.Println(err)
-	}
-
-	for idx := len(sortedMiddlewares) - 1; idx >= 0; idx-- {
-		middleware := sortedMiddlewares[idx]
-
-		if compiledHandler == nil {
-			compiledHandler = middleware.Handler(handler)
-		} else {
-			compiledHandler = middleware.Handler(compiledHandler)
-		}
+		panic(err)
 	}
-
 	return compiledHandler
 }
+
+// Apply apply middlewares to handler, it is the same as MustApply, so an
+// invalid stack fails at startup instead of serving a broken chain
+func (stack *MiddlewareStack) Apply(handler http.Handler) http.Handler {
+	return stack.MustApply(handler)
+}
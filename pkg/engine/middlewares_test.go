@@ -21,10 +21,14 @@ package engine
 // THE SOFTWARE.
 
 import (
+	"errors"
 	"fmt"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
 	"sort"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -85,7 +89,35 @@ func TestCompileComplicatedMiddlewares(t *testing.T) {
 }
 
 func TestConflictingMiddlewares(t *testing.T) {
-	t.Skipf("conflicting middlewares")
+	availableMiddlewares := []Middleware{{Name: "A", InsertBefore: []string{"B"}}, {Name: "B", InsertBefore: []string{"C"}}, {Name: "C", InsertBefore: []string{"A"}}, {Name: "D"}}
+	stack := registerMiddlewareRandomly(availableMiddlewares)
+
+	_, err := stack.sortMiddlewares()
+
+	var cycle *CycleError
+	if !errors.As(err, &cycle) {
+		t.Fatalf("Should return cycle error as constraints are conflicting, but got %v", err)
+	}
+
+	if len(cycle.Path) != 4 || cycle.Path[0] != cycle.Path[3] {
+		t.Fatalf("Expected cycle path to start and end with the same middleware, but got %v", cycle.Path)
+	}
+
+	for idx, name := range cycle.Path[:3] {
+		next := map[string]string{"A": "B", "B": "C", "C": "A"}[name]
+		if cycle.Path[idx+1] != next {
+			t.Errorf("Expected %v to be followed by %v in cycle path, but got %v", name, next, strings.Join(cycle.Path, " -> "))
+		}
+	}
+}
+
+func TestConflictingInsertBeforeAndInsertAfter(t *testing.T) {
+	availableMiddlewares := []Middleware{{Name: "A", InsertBefore: []string{"B"}}, {Name: "B"}, {Name: "C", InsertAfter: []string{"B"}, InsertBefore: []string{"A"}}}
+	stack := registerMiddlewareRandomly(availableMiddlewares)
+
+	if _, err := stack.sortMiddlewares(); err == nil {
+		t.Errorf("Should return error as InsertBefore and InsertAfter constraints are conflicting")
+	}
 }
 
 func TestMiddlewaresWithRequires(t *testing.T) {
@@ -96,3 +128,327 @@ func TestMiddlewaresWithRequires(t *testing.T) {
 		t.Errorf("Should return error as required middleware doesn't exist")
 	}
 }
+
+func TestCompileReturnsMissingRequirementError(t *testing.T) {
+	stack := registerMiddleware([]Middleware{{Name: "flash", Requires: []string{"cookie"}}, {Name: "session"}})
+
+	_, err := stack.Compile(http.NotFoundHandler())
+
+	var missing *MissingRequirementError
+	if !errors.As(err, &missing) {
+		t.Fatalf("Expected a MissingRequirementError, but got %v", err)
+	}
+
+	if missing.Middleware != "flash" || missing.Requirement != "cookie" {
+		t.Errorf("Expected flash to miss cookie, but got %v missing %v", missing.Middleware, missing.Requirement)
+	}
+}
+
+func TestCompileReturnsCycleError(t *testing.T) {
+	stack := registerMiddleware([]Middleware{{Name: "A", InsertAfter: []string{"B"}}, {Name: "B", InsertAfter: []string{"A"}}})
+
+	_, err := stack.Compile(http.NotFoundHandler())
+
+	var cycle *CycleError
+	if !errors.As(err, &cycle) {
+		t.Fatalf("Expected a CycleError, but got %v", err)
+	}
+}
+
+func TestMustApplyPanicsOnInvalidStack(t *testing.T) {
+	stack := registerMiddleware([]Middleware{{Name: "flash", Requires: []string{"cookie"}}})
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Should panic as required middleware doesn't exist")
+		}
+	}()
+
+	stack.MustApply(http.NotFoundHandler())
+}
+
+func TestCompileMiddlewaresOrder(t *testing.T) {
+	var (
+		called []string
+		record = func(name string) func(http.Handler) http.Handler {
+			return func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+					called = append(called, name)
+					next.ServeHTTP(w, req)
+				})
+			}
+		}
+	)
+
+	stack := registerMiddleware([]Middleware{{Name: "auth", Handler: record("auth"), InsertAfter: []string{"cookie"}}, {Name: "cookie", Handler: record("cookie")}})
+	handler, err := stack.Compile(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { called = append(called, "handler") }))
+	if err != nil {
+		t.Fatalf("Failed to compile middlewares, got %v", err)
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if fmt.Sprint(called) != fmt.Sprint([]string{"cookie", "auth", "handler"}) {
+		t.Errorf("Expected middlewares called in order cookie, auth, handler, but got %v", called)
+	}
+
+	if handler, err := (&MiddlewareStack{}).Compile(http.NotFoundHandler()); err != nil || handler == nil {
+		t.Errorf("Empty stack should return the handler itself, but got %v, %v", handler, err)
+	}
+}
+
+func TestSortMiddlewaresDoesNotChangeRegisteredMiddlewares(t *testing.T) {
+	stack := registerMiddleware([]Middleware{{Name: "cookie", InsertBefore: []string{"flash"}}, {Name: "flash"}, {Name: "auth", InsertAfter: []string{"flash"}}})
+	stack.sortMiddlewares()
+
+	for _, middleware := range stack.snapshots.load().middlewares {
+		switch middleware.Name {
+		case "cookie":
+			if len(middleware.InsertAfter) != 0 || fmt.Sprint(middleware.InsertBefore) != "[flash]" {
+				t.Errorf("cookie's constraints should be untouched, but got %v, %v", middleware.InsertAfter, middleware.InsertBefore)
+			}
+		case "flash":
+			if len(middleware.InsertAfter) != 0 || len(middleware.InsertBefore) != 0 {
+				t.Errorf("flash's constraints should be untouched, but got %v, %v", middleware.InsertAfter, middleware.InsertBefore)
+			}
+		}
+	}
+}
+
+func TestSortMiddlewaresTieBreak(t *testing.T) {
+	stack := registerMiddleware([]Middleware{{Name: "A"}, {Name: "B"}, {Name: "C", InsertAfter: []string{"B"}}})
+	checkSortedMiddlewares(stack, []string{"A", "B", "C"}, t)
+
+	stack = registerMiddleware([]Middleware{{Name: "A"}, {Name: "B"}, {Name: "C", Priority: 10}, {Name: "D", Priority: 5, InsertAfter: []string{"B"}}})
+	checkSortedMiddlewares(stack, []string{"C", "A", "B", "D"}, t)
+}
+
+func TestConcurrentUseAndCompile(t *testing.T) {
+	var (
+		wg    sync.WaitGroup
+		stack = &MiddlewareStack{}
+		pass  = func(next http.Handler) http.Handler { return next }
+	)
+
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			stack.Use(Middleware{Name: fmt.Sprint("middleware", i), Handler: pass})
+		}(i)
+		go func() {
+			defer wg.Done()
+			if _, err := stack.Compile(http.NotFoundHandler()); err != nil {
+				t.Errorf("Failed to compile middlewares, got %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if count := len(stack.snapshots.load().middlewares); count != 20 {
+		t.Errorf("Expected 20 registered middlewares, but got %v", count)
+	}
+}
+
+func TestRemoveMiddleware(t *testing.T) {
+	stack := registerMiddleware([]Middleware{{Name: "cookie"}, {Name: "flash"}, {Name: "session"}, {Name: "auth"}})
+
+	if !stack.Remove("session") {
+		t.Errorf("Should remove registered middleware session")
+	}
+
+	if stack.Remove("session") {
+		t.Errorf("Should not remove middleware session twice")
+	}
+
+	if fmt.Sprint(stack.Names()) != "[cookie flash auth]" {
+		t.Errorf("Expected middlewares cookie, flash, auth after removing session, but got %v", stack.Names())
+	}
+
+	stack.Remove("cookie")
+	stack.Remove("auth")
+	if fmt.Sprint(stack.Names()) != "[flash]" || stack.Len() != 1 {
+		t.Errorf("Expected only middleware flash left, but got %v", stack.Names())
+	}
+}
+
+func TestUseDuplicateMiddleware(t *testing.T) {
+	stack := registerMiddleware([]Middleware{{Name: "cookie"}, {Name: "flash"}})
+
+	var duplicate *DuplicateMiddlewareError
+	if err := stack.Use(Middleware{Name: "cookie"}); !errors.As(err, &duplicate) {
+		t.Errorf("Should return duplicate error when using a registered name, but got %v", err)
+	}
+
+	if err := stack.Use(Middleware{Name: "cookie", Requires: []string{"flash"}}, ReplaceExisting()); err != nil {
+		t.Errorf("Should replace registered middleware, but got %v", err)
+	}
+
+	if cookie, ok := stack.Get("cookie"); !ok || fmt.Sprint(cookie.Requires) != "[flash]" {
+		t.Errorf("Expected cookie to be replaced, but got %v", cookie)
+	}
+
+	if fmt.Sprint(stack.Names()) != "[cookie flash]" {
+		t.Errorf("Replaced middleware should keep its position, but got %v", stack.Names())
+	}
+}
+
+func TestReplaceMiddleware(t *testing.T) {
+	stack := registerMiddleware([]Middleware{{Name: "cookie"}, {Name: "flash"}})
+
+	if err := stack.Replace("cookie", Middleware{Name: "session"}); err != nil {
+		t.Errorf("Should replace cookie with session, but got %v", err)
+	}
+
+	if stack.Has("cookie") || !stack.Has("session") || fmt.Sprint(stack.Names()) != "[session flash]" {
+		t.Errorf("Expected middlewares session, flash, but got %v", stack.Names())
+	}
+
+	var notFound *MiddlewareNotFoundError
+	if err := stack.Replace("cookie", Middleware{Name: "cookie"}); !errors.As(err, &notFound) {
+		t.Errorf("Should return not found error when replacing unregistered middleware, but got %v", err)
+	}
+
+	var duplicate *DuplicateMiddlewareError
+	if err := stack.Replace("session", Middleware{Name: "flash"}); !errors.As(err, &duplicate) {
+		t.Errorf("Should return duplicate error when renaming to a registered name, but got %v", err)
+	}
+}
+
+func TestScopedMiddlewares(t *testing.T) {
+	auth := headerMiddleware("auth")
+	auth.Paths = []string{"/api/"}
+	auth.Methods = []string{"POST", "PUT"}
+
+	admin := headerMiddleware("admin")
+	admin.Hosts = []string{"*.example.com"}
+	admin.Match = func(req *http.Request) bool { return req.URL.Query().Get("debug") == "" }
+
+	stack := &MiddlewareStack{}
+	stack.Use(auth)
+	stack.Use(admin)
+	handler := stack.MustApply(http.NotFoundHandler())
+
+	cases := []struct {
+		method, target string
+		expected       []string
+	}{
+		{"POST", "http://localhost/api/users", []string{"auth"}},
+		{"GET", "http://localhost/api/users", nil},
+		{"POST", "http://localhost//api/users", []string{"auth"}},
+		{"POST", "http://localhost/x/../api/users", []string{"auth"}},
+		{"POST", "http://localhost/api", []string{"auth"}},
+		{"POST", "http://localhost/apiary", nil},
+		{"PUT", "http://localhost/healthz", nil},
+		{"POST", "http://admin.example.com:8080/api/users", []string{"auth", "admin"}},
+		{"GET", "http://admin.example.com/", []string{"admin"}},
+		{"GET", "http://admin.example.com/?debug=1", nil},
+		{"GET", "http://example.com/", nil},
+	}
+
+	for _, c := range cases {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(c.method, c.target, nil))
+		if names := recorder.Header()["X-Middleware"]; fmt.Sprint(names) != fmt.Sprint(c.expected) {
+			t.Errorf("Expected middlewares %v for %v %v, but got %v", c.expected, c.method, c.target, names)
+		}
+	}
+}
+
+func TestCleanPath(t *testing.T) {
+	for p, expected := range map[string]string{
+		"":                "/",
+		"/api/users":      "/api/users",
+		"//api/users":     "/api/users",
+		"/x/../api/users": "/api/users",
+		"/api/./users/":   "/api/users/",
+		"api":             "/api",
+		"/..":             "/",
+	} {
+		if cleaned := CleanPath(p); cleaned != expected {
+			t.Errorf("Expected %q to be cleaned as %q, but got %q", p, expected, cleaned)
+		}
+	}
+}
+
+func TestMountGroups(t *testing.T) {
+	csrf := headerMiddleware("csrf")
+	csrf.InsertAfter = []string{"auth"}
+	security := registerMiddleware([]Middleware{csrf, headerMiddleware("auth"), headerMiddleware("secure_headers")})
+
+	stack := &MiddlewareStack{}
+	stack.Use(headerMiddleware("logging"))
+	stack.Use(Middleware{Name: "security", Group: security, InsertBefore: []string{"logging"}, Requires: []string{"request_id"}})
+	stack.Use(Middleware{Name: "request_id", Handler: headerMiddleware("request_id").Handler, InsertBefore: []string{"security"}})
+
+	if str := stack.String(); str != "MiddlewareStack: request_id, security[auth, csrf, secure_headers], logging" {
+		t.Errorf("Expected stack to show its groups, but got %v", str)
+	}
+
+	handler, err := stack.ApplyLive(http.NotFoundHandler())
+	if err != nil {
+		t.Fatalf("Failed to apply middlewares, got %v", err)
+	}
+
+	if names := serveLive(handler); fmt.Sprint(names) != "[request_id auth csrf secure_headers logging]" {
+		t.Errorf("Expected group middlewares to be applied in place, but got %v", names)
+	}
+
+	security.Remove("secure_headers")
+	if names := serveLive(handler); fmt.Sprint(names) != "[request_id auth csrf logging]" {
+		t.Errorf("Expected group changes to be picked up, but got %v", names)
+	}
+
+	security.Use(Middleware{Name: "cors", Requires: []string{"origin"}})
+	var missing *MissingRequirementError
+	if _, err := stack.Compile(http.NotFoundHandler()); !errors.As(err, &missing) || missing.Middleware != "cors" {
+		t.Errorf("Expected group errors to be reported, but got %v", err)
+	}
+}
+
+func TestMountGroupIntoItself(t *testing.T) {
+	stack, security := &MiddlewareStack{}, &MiddlewareStack{}
+	stack.Use(Middleware{Name: "security", Group: security})
+	security.Use(Middleware{Name: "parent", Group: stack})
+
+	var cycle *CycleError
+	if _, err := stack.Compile(http.NotFoundHandler()); !errors.As(err, &cycle) {
+		t.Errorf("Should return cycle error when a group is mounted into itself, but got %v", err)
+	}
+
+	if str := stack.String(); str != "MiddlewareStack: security[parent[...]]" {
+		t.Errorf("Expected nested groups to be shown once, but got %v", str)
+	}
+}
+
+func TestOptionalConstraints(t *testing.T) {
+	availableMiddlewares := []Middleware{{Name: "A"}, {Name: "B", OptionalBefore: []string{"A"}}, {Name: "C", OptionalAfter: []string{"missing"}}}
+	stack := registerMiddleware(availableMiddlewares)
+	checkSortedMiddlewares(stack, []string{"B", "A", "C"}, t)
+
+	// optional constraints contradicting required ones are dropped
+	availableMiddlewares = []Middleware{{Name: "A", InsertBefore: []string{"B"}}, {Name: "B", OptionalBefore: []string{"A"}}, {Name: "C", OptionalAfter: []string{"B"}, OptionalBefore: []string{"A"}}}
+	stack = registerMiddleware(availableMiddlewares)
+	checkSortedMiddlewares(stack, []string{"A", "B", "C"}, t)
+}
+
+func TestConflictsMiddlewares(t *testing.T) {
+	stack := registerMiddleware([]Middleware{{Name: "cookie_session", Conflicts: []string{"db_session"}}, {Name: "db_session", Conflicts: []string{"cookie_session"}}, {Name: "flash", Requires: []string{"session"}}})
+
+	_, err := stack.Compile(http.NotFoundHandler())
+
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) || conflict.Middleware != "cookie_session" || conflict.Conflict != "db_session" {
+		t.Fatalf("Should return conflict error, but got %v", err)
+	}
+
+	var stackError *StackError
+	if !errors.As(err, &stackError) || len(stackError.Errors) != 2 {
+		t.Errorf("Expected conflict and missing requirement to be reported together, but got %v", err)
+	}
+
+	stack.Remove("db_session")
+	stack.Use(Middleware{Name: "session"})
+	if _, err := stack.Compile(http.NotFoundHandler()); err != nil {
+		t.Errorf("Should compile without conflicting middlewares, but got %v", err)
+	}
+}
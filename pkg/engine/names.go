@@ -0,0 +1,46 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Names of built-in middlewares, they declare their ordering constraints with these names
+const (
+	RecoverName       = "recover"
+	RequestIDName     = "request_id"
+	AccessLogName     = "access_log"
+	RateLimitName     = "rate_limit"
+	CORSName          = "cors"
+	CompressName      = "compress"
+	SecureHeadersName = "secure_headers"
+	CSRFName          = "csrf"
+	SessionName       = "session"
+
+	// Names of the middlewares of package auth
+	BasicAuthName  = "basic_auth"
+	BearerAuthName = "bearer_auth"
+	APIKeyAuthName = "api_key_auth"
+	AuthorizeName  = "authorize"
+)
+
+// builtinNames names of all built-in middlewares
+var builtinNames = []string{RecoverName, RequestIDName, AccessLogName, RateLimitName, CORSName, CompressName, SecureHeadersName, CSRFName, SessionName, BasicAuthName, BearerAuthName, APIKeyAuthName, AuthorizeName}
+
+// authNames names of the authentication middlewares
+var authNames = []string{BasicAuthName, BearerAuthName, APIKeyAuthName}
@@ -0,0 +1,153 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"strings"
+	"sync"
+)
+
+// Ordering name and ordering constraints of a middleware of the stacks of
+// other transports, like RoundTripperMiddleware and UnaryInterceptor, they
+// have the same meaning as the fields of Middleware
+type Ordering struct {
+	Name           string
+	InsertAfter    []string
+	InsertBefore   []string
+	Requires       []string
+	OptionalAfter  []string
+	OptionalBefore []string
+	Conflicts      []string
+	Priority       int
+}
+
+func (o *Ordering) ordering() ordering {
+	return ordering{
+		name:           o.Name,
+		insertBefore:   o.InsertBefore,
+		insertAfter:    o.InsertAfter,
+		optionalBefore: o.OptionalBefore,
+		optionalAfter:  o.OptionalAfter,
+		requires:       o.Requires,
+		conflicts:      o.Conflicts,
+		priority:       o.Priority,
+	}
+}
+
+// orderedList entries sorted by their ordering constraints, it backs the
+// stacks of other transports, which share the ordering rules and errors of
+// MiddlewareStack. Stacks embed it for Remove and Names. It is safe for concurrent use
+type orderedList struct {
+	mu      sync.RWMutex
+	entries []orderedEntry
+}
+
+type orderedEntry struct {
+	ordering ordering
+	value    interface{}
+}
+
+// use adds value, or replaces the entry with the same name if replace is true
+func (list *orderedList) use(o ordering, value interface{}, options []UseOption) error {
+	var opts useOptions
+	for _, option := range options {
+		option(&opts)
+	}
+
+	list.mu.Lock()
+	defer list.mu.Unlock()
+
+	for idx, entry := range list.entries {
+		if entry.ordering.name == o.name {
+			if !opts.replace {
+				return &DuplicateMiddlewareError{Name: o.name}
+			}
+			list.entries[idx] = orderedEntry{ordering: o, value: value}
+			return nil
+		}
+	}
+
+	list.entries = append(list.entries, orderedEntry{ordering: o, value: value})
+	return nil
+}
+
+// Remove remove entry name, returns false if it isn't registered
+func (list *orderedList) Remove(name string) bool {
+	list.mu.Lock()
+	defer list.mu.Unlock()
+
+	for idx, entry := range list.entries {
+		if entry.ordering.name == name {
+			list.entries = append(list.entries[:idx:idx], list.entries[idx+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Names returns names of registered entries in registration order
+func (list *orderedList) Names() []string {
+	list.mu.RLock()
+	defer list.mu.RUnlock()
+
+	var names []string
+	for _, entry := range list.entries {
+		names = append(names, entry.ordering.name)
+	}
+	return names
+}
+
+// sorted returns entries sorted by their ordering constraints
+func (list *orderedList) sorted() ([]orderedEntry, error) {
+	list.mu.RLock()
+	entries := append([]orderedEntry{}, list.entries...)
+	list.mu.RUnlock()
+
+	orderings := make([]ordering, len(entries))
+	for idx, entry := range entries {
+		orderings[idx] = entry.ordering
+	}
+
+	sorted, err := sortOrderings(orderings)
+	if err != nil {
+		return nil, err
+	}
+
+	sortedEntries := make([]orderedEntry, len(sorted))
+	for pos, idx := range sorted {
+		sortedEntries[pos] = entries[idx]
+	}
+	return sortedEntries, nil
+}
+
+// describe returns the sorted names of entries, for String methods of stacks
+func (list *orderedList) describe(stackType string) string {
+	sortedEntries, err := list.sorted()
+	if err != nil {
+		return stackType + ": <invalid: " + err.Error() + ">"
+	}
+
+	var sortedNames []string
+	for _, entry := range sortedEntries {
+		sortedNames = append(sortedNames, entry.ordering.name)
+	}
+	return stackType + ": " + strings.Join(sortedNames, ", ")
+}
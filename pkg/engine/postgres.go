@@ -20,22 +20,22 @@ package engine
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
 // THE SOFTWARE.
 
-// getRIndex get right index from string slice
-func getRIndex(strs []string, str string) (int, bool) {
-	for i := len(strs) - 1; i >= 0; i-- {
-		if strs[i] == str {
-			return i, true
-		}
-	}
-	return -1, false
-}
+import (
+	"database/sql"
+	"time"
+)
 
-func uniqueAppend(strs []string, str string) []string {
-	for _, s := range strs {
-		if s == str {
-			return strs
-		}
-	}
+// postgresTimeout bounds creating the table of a PostgreSQL store when a middleware is built
+const postgresTimeout = 10 * time.Second
 
-	return append(strs, str)
+// sharedPostgres returns the PostgreSQL handle of dsn, it is shared by the
+// builds of params' registry, so its connection pool outlives stack rebuilds
+func sharedPostgres(params Params, dsn string) (*sql.DB, error) {
+	db, err := params.Shared("postgres:"+dsn, func() (interface{}, error) {
+		return sql.Open("postgres", dsn)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return db.(*sql.DB), nil
 }
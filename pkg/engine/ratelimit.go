@@ -0,0 +1,383 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// RateLimitAlgorithm algorithm of RateLimit
+type RateLimitAlgorithm string
+
+// Algorithms of RateLimit
+const (
+	// TokenBucket allows bursts up to Burst requests, refilled at Limit per Period
+	TokenBucket RateLimitAlgorithm = "token_bucket"
+	// SlidingWindow allows Limit requests in any Period, weighting the previous window's count
+	SlidingWindow RateLimitAlgorithm = "sliding_window"
+)
+
+// RateLimitState counters of a rate limit key. For TokenBucket, Value is the
+// tokens left at Stamp, for SlidingWindow, Value and Previous are the counts
+// of the window started at Stamp and of the window before it
+type RateLimitState struct {
+	Value    float64
+	Previous float64
+	Stamp    time.Time
+}
+
+// RateLimitStore stores counters of rate limit keys
+type RateLimitStore interface {
+	// Update applies fn to the state of key and saves it atomically, unknown
+	// or expired keys start from the zero state, which could be dropped after ttl
+	Update(ctx context.Context, key string, ttl time.Duration, fn func(state *RateLimitState)) error
+}
+
+// RateLimitKeyFunc returns the key of req to count requests by, requests
+// with a blank key aren't limited
+type RateLimitKeyFunc func(req *http.Request) string
+
+// KeyByIP counts requests by client IP, taken from proxy headers added by
+// trustedProxies reverse proxies in front of the server, like AccessLogOptions.TrustedProxies
+func KeyByIP(trustedProxies int) RateLimitKeyFunc {
+	return func(req *http.Request) string {
+		return "ip:" + remoteIP(req, trustedProxies)
+	}
+}
+
+// KeyByHeader counts requests by the value of header, like an API key
+func KeyByHeader(header string) RateLimitKeyFunc {
+	return func(req *http.Request) string {
+		if value := req.Header.Get(header); value != "" {
+			return "header:" + header + ":" + value
+		}
+		return ""
+	}
+}
+
+// RateLimitOptions options of RateLimit
+type RateLimitOptions struct {
+	// Algorithm is TokenBucket if it is blank
+	Algorithm RateLimitAlgorithm
+	// Limit requests are allowed per Period
+	Limit  int
+	Period time.Duration
+	// Burst capacity of TokenBucket, Limit if it is zero
+	Burst int
+	// Key is KeyByIP(0) if it is nil
+	Key RateLimitKeyFunc
+	// Store is a new in memory store if it is nil
+	Store RateLimitStore
+	// FailClosed rejects requests when the store fails, they are allowed by default
+	FailClosed bool
+	// Logger is log.StandardLogger() if it is nil
+	Logger log.FieldLogger
+}
+
+// RateLimitResult decision of a rate limit
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// RateLimit returns a middleware limiting requests per key, it sets the
+// RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers, and
+// responds with 429 and Retry-After to requests over the limit
+func RateLimit(options RateLimitOptions) Middleware {
+	if options.Algorithm == "" {
+		options.Algorithm = TokenBucket
+	}
+
+	if options.Burst == 0 {
+		options.Burst = options.Limit
+	}
+
+	if options.Key == nil {
+		options.Key = KeyByIP(0)
+	}
+
+	if options.Store == nil {
+		options.Store = NewMemoryRateLimitStore()
+	}
+
+	if options.Logger == nil {
+		options.Logger = log.StandardLogger()
+	}
+
+	return Middleware{
+		Name:        RateLimitName,
+		InsertAfter: []string{RequestIDName, AccessLogName},
+		Validate: func() error {
+			if options.Limit <= 0 || options.Period <= 0 {
+				return fmt.Errorf("limit %v per %v should be positive", options.Limit, options.Period)
+			}
+
+			if options.Burst < 0 {
+				return fmt.Errorf("burst %v should be positive", options.Burst)
+			}
+
+			if options.Algorithm != TokenBucket && options.Algorithm != SlidingWindow {
+				return fmt.Errorf("unknown algorithm %v", options.Algorithm)
+			}
+			return nil
+		},
+		Handler: func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				key := options.Key(req)
+				if key == "" {
+					next.ServeHTTP(w, req)
+					return
+				}
+
+				var (
+					result RateLimitResult
+					now    = time.Now()
+				)
+
+				err := options.Store.Update(req.Context(), key, 2*options.Period, func(state *RateLimitState) {
+					result = options.take(state, now)
+				})
+				if err != nil {
+					options.Logger.WithError(err).WithField("key", key).Error("cannot update rate limit")
+					if options.FailClosed {
+						RenderError(w, req, http.StatusServiceUnavailable, http.StatusText(http.StatusServiceUnavailable))
+					} else {
+						next.ServeHTTP(w, req)
+					}
+					return
+				}
+
+				w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+				w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+				w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+
+				if !result.Allowed {
+					w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+					RenderError(w, req, http.StatusTooManyRequests, http.StatusText(http.StatusTooManyRequests))
+					return
+				}
+
+				next.ServeHTTP(w, req)
+			})
+		},
+	}
+}
+
+// take counts a request at now in state
+func (options RateLimitOptions) take(state *RateLimitState, now time.Time) RateLimitResult {
+	if options.Algorithm == SlidingWindow {
+		return takeSlidingWindow(state, now, options.Limit, options.Period)
+	}
+	return takeTokenBucket(state, now, options.Burst, float64(options.Limit)/options.Period.Seconds())
+}
+
+// takeTokenBucket takes a token of a bucket holding up to capacity tokens, refilled by rate tokens per second
+func takeTokenBucket(state *RateLimitState, now time.Time, capacity int, rate float64) RateLimitResult {
+	tokens := float64(capacity)
+	if !state.Stamp.IsZero() {
+		tokens = math.Min(tokens, state.Value+now.Sub(state.Stamp).Seconds()*rate)
+	}
+
+	result := RateLimitResult{Limit: capacity}
+	if tokens >= 1 {
+		tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = secondsDuration((1 - tokens) / rate)
+	}
+
+	state.Value, state.Stamp = tokens, now
+	result.Remaining = int(tokens)
+	result.Reset = secondsDuration((float64(capacity) - tokens) / rate)
+	return result
+}
+
+// takeSlidingWindow counts a request in the window of period containing now,
+// requests of the previous window are weighted by its share still in the last period
+func takeSlidingWindow(state *RateLimitState, now time.Time, limit int, period time.Duration) RateLimitResult {
+	start := now.Truncate(period)
+	if !state.Stamp.Equal(start) {
+		if state.Stamp.Equal(start.Add(-period)) {
+			state.Previous = state.Value
+		} else {
+			state.Previous = 0
+		}
+		state.Value, state.Stamp = 0, start
+	}
+
+	var (
+		weight    = 1 - float64(now.Sub(start))/float64(period)
+		estimated = state.Previous*weight + state.Value
+		result    = RateLimitResult{Limit: limit, Reset: start.Add(period).Sub(now)}
+	)
+
+	if estimated+1 <= float64(limit) {
+		state.Value++
+		estimated++
+		result.Allowed = true
+	} else if free := float64(limit) - 1 - state.Value; free >= 0 && state.Previous > 0 {
+		// wait until enough of the previous window slides out
+		result.RetryAfter = start.Add(time.Duration((1 - free/state.Previous) * float64(period))).Sub(now)
+	} else {
+		result.RetryAfter = result.Reset
+	}
+
+	result.Remaining = int(math.Max(0, float64(limit)-estimated))
+	return result
+}
+
+func secondsDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// MemoryRateLimitStore in memory RateLimitStore, it is only shared by the current process
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	entries   map[string]*memoryRateLimitEntry
+	lastSweep time.Time
+}
+
+type memoryRateLimitEntry struct {
+	state   RateLimitState
+	expires time.Time
+}
+
+// NewMemoryRateLimitStore returns a new in memory RateLimitStore
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{entries: map[string]*memoryRateLimitEntry{}, lastSweep: time.Now()}
+}
+
+// Update applies fn to the state of key
+func (store *MemoryRateLimitStore) Update(_ context.Context, key string, ttl time.Duration, fn func(state *RateLimitState)) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(store.lastSweep) > time.Minute {
+		for k, entry := range store.entries {
+			if now.After(entry.expires) {
+				delete(store.entries, k)
+			}
+		}
+		store.lastSweep = now
+	}
+
+	entry, ok := store.entries[key]
+	if !ok || now.After(entry.expires) {
+		entry = &memoryRateLimitEntry{}
+		store.entries[key] = entry
+	}
+
+	fn(&entry.state)
+	entry.expires = now.Add(ttl)
+	return nil
+}
+
+func init() {
+	RegisterFactory(RateLimitName, Factory{
+		Description: "limits requests per client IP or header",
+		Params: []Param{
+			{Name: "algorithm", Type: StringParam, Default: string(TokenBucket), Description: "token_bucket or sliding_window"},
+			{Name: "limit", Type: IntParam, Required: true},
+			{Name: "period", Type: DurationParam, Required: true},
+			{Name: "burst", Type: IntParam},
+			{Name: "key", Type: StringParam, Default: "ip", Description: "ip, or header:<name>"},
+			{Name: "trusted_proxies", Type: IntParam, Description: "number of reverse proxies adding X-Forwarded-For"},
+			{Name: "fail_closed", Type: BoolParam},
+			{Name: "postgres_dsn", Type: StringParam, Description: "shares counters in PostgreSQL instead of memory"},
+			{Name: "postgres_table", Type: StringParam, Default: "rate_limits"},
+		},
+		New: func(params Params) (Middleware, error) {
+			options := RateLimitOptions{
+				Algorithm:  RateLimitAlgorithm(params.String("algorithm")),
+				Limit:      params.Int("limit"),
+				Period:     params.Duration("period"),
+				Burst:      params.Int("burst"),
+				FailClosed: params.Bool("fail_closed"),
+			}
+
+			switch key := params.String("key"); {
+			case key == "ip":
+				options.Key = KeyByIP(params.Int("trusted_proxies"))
+			case strings.HasPrefix(key, "header:"):
+				options.Key = KeyByHeader(strings.TrimPrefix(key, "header:"))
+			default:
+				return Middleware{}, fmt.Errorf("unknown rate limit key %v", key)
+			}
+
+			store, err := sharedRateLimitStore(params, options.Algorithm)
+			if err != nil {
+				return Middleware{}, err
+			}
+			options.Store = store
+
+			return RateLimit(options), nil
+		},
+	})
+}
+
+// sharedRateLimitStore returns the store of the rate limit built from params,
+// it is shared by rebuilds of the stack, so counters survive config reloads
+func sharedRateLimitStore(params Params, algorithm RateLimitAlgorithm) (RateLimitStore, error) {
+	dsn := params.String("postgres_dsn")
+	if dsn == "" {
+		store, _ := params.Shared(fmt.Sprintf("%v:memory:%v:%v", RateLimitName, params.Name(), algorithm), func() (interface{}, error) {
+			return NewMemoryRateLimitStore(), nil
+		})
+		return store.(RateLimitStore), nil
+	}
+
+	db, err := sharedPostgres(params, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	table := params.String("postgres_table")
+	store, err := params.Shared(fmt.Sprintf("%v:postgres:%v:%v", RateLimitName, dsn, table), func() (interface{}, error) {
+		store := NewPostgresRateLimitStore(db, table)
+		ctx, cancel := context.WithTimeout(context.Background(), postgresTimeout)
+		defer cancel()
+		if err := store.CreateTable(ctx); err != nil {
+			return nil, fmt.Errorf("cannot create rate limit table %v: %w", table, err)
+		}
+		return store, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return store.(RateLimitStore), nil
+}
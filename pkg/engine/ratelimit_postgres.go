@@ -0,0 +1,140 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// DefaultRateLimitGCInterval how often PostgresRateLimitStore deletes expired counters by default
+const DefaultRateLimitGCInterval = 10 * time.Minute
+
+// PostgresRateLimitStore RateLimitStore keeping counters in a PostgreSQL
+// table, so limits are shared by all replicas of a service. Every update
+// locks the key's row in a transaction. Expired rows are deleted every
+// GCInterval while updating counters, or by calling DeleteExpired
+type PostgresRateLimitStore struct {
+	// GCInterval is DefaultRateLimitGCInterval if it is zero, expired rows are only deleted by DeleteExpired if it is negative
+	GCInterval time.Duration
+
+	db     *sql.DB
+	table  string
+	mu     sync.Mutex
+	lastGC time.Time
+}
+
+// NewPostgresRateLimitStore returns a store keeping counters in table of db, see CreateTable
+func NewPostgresRateLimitStore(db *sql.DB, table string) *PostgresRateLimitStore {
+	return &PostgresRateLimitStore{db: db, table: pq.QuoteIdentifier(table), lastGC: time.Now()}
+}
+
+// CreateTable creates the store's table if it doesn't exist
+func (store *PostgresRateLimitStore) CreateTable(ctx context.Context) error {
+	_, err := store.db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	key TEXT PRIMARY KEY,
+	value DOUBLE PRECISION NOT NULL DEFAULT 0,
+	previous DOUBLE PRECISION NOT NULL DEFAULT 0,
+	stamp TIMESTAMPTZ,
+	expires_at TIMESTAMPTZ NOT NULL
+)`, store.table))
+	return err
+}
+
+// Update applies fn to the state of key
+func (store *PostgresRateLimitStore) Update(ctx context.Context, key string, ttl time.Duration, fn func(state *RateLimitState)) (err error) {
+	tx, err := store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	now := time.Now()
+	if _, err = tx.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (key, expires_at) VALUES ($1, $2) ON CONFLICT (key) DO NOTHING`, store.table), key, now.Add(ttl)); err != nil {
+		return err
+	}
+
+	var (
+		state     RateLimitState
+		stamp     sql.NullTime
+		expiresAt time.Time
+	)
+	row := tx.QueryRowContext(ctx, fmt.Sprintf(`SELECT value, previous, stamp, expires_at FROM %s WHERE key = $1 FOR UPDATE`, store.table), key)
+	if err = row.Scan(&state.Value, &state.Previous, &stamp, &expiresAt); err != nil {
+		return err
+	}
+
+	if now.After(expiresAt) {
+		state = RateLimitState{}
+	} else if stamp.Valid {
+		state.Stamp = stamp.Time
+	}
+
+	fn(&state)
+
+	stamp = sql.NullTime{Time: state.Stamp, Valid: !state.Stamp.IsZero()}
+	if _, err = tx.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET value = $2, previous = $3, stamp = $4, expires_at = $5 WHERE key = $1`, store.table), key, state.Value, state.Previous, stamp, now.Add(ttl)); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	store.collectGarbage()
+	return nil
+}
+
+// DeleteExpired deletes expired counters, returns count of deleted rows
+func (store *PostgresRateLimitStore) DeleteExpired(ctx context.Context) (int64, error) {
+	result, err := store.db.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE expires_at < $1`, store.table), time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// collectGarbage deletes expired counters in the background if GCInterval passed since the last time
+func (store *PostgresRateLimitStore) collectGarbage() {
+	interval := store.GCInterval
+	if interval == 0 {
+		interval = DefaultRateLimitGCInterval
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if interval < 0 || time.Since(store.lastGC) < interval {
+		return
+	}
+	store.lastGC = time.Now()
+
+	go store.DeleteExpired(context.Background())
+}
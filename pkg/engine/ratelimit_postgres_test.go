@@ -0,0 +1,282 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubDB database/sql driver recording statements, queries are answered by rows
+type stubDB struct {
+	mu         sync.Mutex
+	statements []stubStatement
+	rows       func(query string) ([]string, [][]driver.Value)
+	err        func(query string) error
+	commits    int
+	rollbacks  int
+}
+
+type stubStatement struct {
+	query string
+	args  []driver.Value
+}
+
+func (db *stubDB) open() *sql.DB {
+	return sql.OpenDB(db)
+}
+
+// find returns the recorded statements starting with prefix
+func (db *stubDB) find(prefix string) []stubStatement {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var statements []stubStatement
+	for _, statement := range db.statements {
+		if strings.HasPrefix(statement.query, prefix) {
+			statements = append(statements, statement)
+		}
+	}
+	return statements
+}
+
+func (db *stubDB) record(query string, args []driver.Value) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.statements = append(db.statements, stubStatement{query: query, args: args})
+	if db.err != nil {
+		return db.err(query)
+	}
+	return nil
+}
+
+func (db *stubDB) Connect(context.Context) (driver.Conn, error) { return stubConn{db}, nil }
+func (db *stubDB) Driver() driver.Driver                        { return db }
+func (db *stubDB) Open(string) (driver.Conn, error)             { return stubConn{db}, nil }
+
+type stubConn struct{ db *stubDB }
+
+func (conn stubConn) Prepare(query string) (driver.Stmt, error) {
+	return stubStmt{db: conn.db, query: query}, nil
+}
+func (conn stubConn) Close() error              { return nil }
+func (conn stubConn) Begin() (driver.Tx, error) { return stubTx{conn.db}, nil }
+
+type stubTx struct{ db *stubDB }
+
+func (tx stubTx) Commit() error {
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+	tx.db.commits++
+	return nil
+}
+
+func (tx stubTx) Rollback() error {
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+	tx.db.rollbacks++
+	return nil
+}
+
+type stubStmt struct {
+	db    *stubDB
+	query string
+}
+
+func (stmt stubStmt) Close() error  { return nil }
+func (stmt stubStmt) NumInput() int { return -1 }
+
+func (stmt stubStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if err := stmt.db.record(stmt.query, args); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (stmt stubStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if err := stmt.db.record(stmt.query, args); err != nil {
+		return nil, err
+	}
+
+	rows := &stubRows{}
+	if stmt.db.rows != nil {
+		rows.columns, rows.values = stmt.db.rows(stmt.query)
+	}
+	return rows, nil
+}
+
+type stubRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (rows *stubRows) Columns() []string { return rows.columns }
+func (rows *stubRows) Close() error      { return nil }
+
+func (rows *stubRows) Next(dest []driver.Value) error {
+	if len(rows.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, rows.values[0])
+	rows.values = rows.values[1:]
+	return nil
+}
+
+func TestPostgresRateLimitStore(t *testing.T) {
+	var (
+		stamp = time.Now().Add(-time.Second).UTC()
+		db    = &stubDB{}
+		store = NewPostgresRateLimitStore(db.open(), `rate"limits`)
+		ctx   = context.Background()
+	)
+
+	if err := store.CreateTable(ctx); err != nil || len(db.find(`CREATE TABLE IF NOT EXISTS "rate""limits"`)) != 1 {
+		t.Fatalf("Expected table with quoted name to be created, but got %v %v", err, db.statements)
+	}
+
+	for _, expired := range []bool{false, true} {
+		*db = stubDB{rows: func(string) ([]string, [][]driver.Value) {
+			expiresAt := time.Now().Add(time.Minute)
+			if expired {
+				expiresAt = time.Now().Add(-time.Minute)
+			}
+			return []string{"value", "previous", "stamp", "expires_at"}, [][]driver.Value{{3.0, 1.0, stamp, expiresAt}}
+		}}
+
+		var state RateLimitState
+		err := store.Update(ctx, "ip:1", time.Minute, func(s *RateLimitState) {
+			state = *s
+			s.Value++
+		})
+		if err != nil {
+			t.Fatalf("Failed to update counter, got %v", err)
+		}
+
+		expected := RateLimitState{Value: 3, Previous: 1, Stamp: stamp}
+		if expired {
+			expected = RateLimitState{}
+		}
+		if state != expected {
+			t.Errorf("Expected state %+v of expired %v row, but got %+v", expected, expired, state)
+		}
+
+		var queries []string
+		for _, statement := range db.statements {
+			queries = append(queries, strings.Fields(statement.query)[0])
+		}
+		if strings.Join(queries, " ") != "INSERT SELECT UPDATE" || db.commits != 1 || db.rollbacks != 0 {
+			t.Errorf("Expected insert, select and update in a committed transaction, but got %v, %v commits", queries, db.commits)
+		}
+
+		if update := db.find("UPDATE"); len(update) != 1 || update[0].args[0] != "ip:1" || update[0].args[1] != expected.Value+1 || !strings.Contains(update[0].query, `"rate""limits"`) {
+			t.Errorf("Expected incremented value to be saved, but got %v", update)
+		}
+
+		if lock := db.find("SELECT"); len(lock) != 1 || !strings.HasSuffix(lock[0].query, "WHERE key = $1 FOR UPDATE") {
+			t.Errorf("Expected row of the key to be locked, but got %v", db.statements)
+		}
+	}
+
+	failure := errors.New("connection reset")
+	*db = stubDB{err: func(query string) error {
+		if strings.HasPrefix(query, "SELECT") {
+			return failure
+		}
+		return nil
+	}}
+	if err := store.Update(ctx, "ip:1", time.Minute, func(*RateLimitState) { t.Errorf("Expected fn not to be called on failure") }); !errors.Is(err, failure) || db.rollbacks != 1 || db.commits != 0 {
+		t.Errorf("Expected failed update to be rolled back, but got %v, %v rollbacks", err, db.rollbacks)
+	}
+
+	if count, err := store.DeleteExpired(ctx); err != nil || count != 1 || len(db.find("DELETE FROM")) != 1 {
+		t.Errorf("Expected expired counters to be deleted, but got %v %v", count, err)
+	}
+}
+
+func TestRateLimitFactoryCreatesPostgresTable(t *testing.T) {
+	factory, _ := DefaultRegistry.Lookup(RateLimitName)
+	config, err := ParseConfig([]byte(`middlewares: [{name: rate_limit, params: {limit: 1, period: 1h, postgres_dsn: stub}}]`))
+	if err != nil {
+		t.Fatalf("Failed to parse config, got %v", err)
+	}
+
+	for _, fail := range []bool{false, true} {
+		db := &stubDB{}
+		if fail {
+			db.err = func(string) error { return errors.New("permission denied") }
+		}
+
+		registry := &Registry{values: map[string]interface{}{"postgres:stub": db.open()}}
+		registry.Register(RateLimitName, factory)
+
+		_, err := registry.Build(config)
+		if fail && (err == nil || !strings.Contains(err.Error(), "cannot create rate limit table rate_limits: permission denied")) {
+			t.Errorf("Expected build to fail on missing table, but got %v", err)
+		}
+
+		if !fail && (err != nil || len(db.find("CREATE TABLE")) != 1) {
+			t.Errorf("Expected build to create table, but got %v %v", err, db.statements)
+		}
+	}
+}
+
+func TestPostgresRateLimitStoreCollectsGarbage(t *testing.T) {
+	var (
+		db    = &stubDB{}
+		store = NewPostgresRateLimitStore(db.open(), "rate_limits")
+		ctx   = context.Background()
+	)
+	db.rows = func(string) ([]string, [][]driver.Value) {
+		return []string{"value", "previous", "stamp", "expires_at"}, [][]driver.Value{{0.0, 0.0, nil, time.Now().Add(time.Minute)}}
+	}
+
+	update := func() {
+		if err := store.Update(ctx, "ip:1", time.Minute, func(s *RateLimitState) { s.Value++ }); err != nil {
+			t.Fatalf("Failed to update counter, got %v", err)
+		}
+	}
+
+	update()
+	if deleted := db.find("DELETE FROM"); len(deleted) != 0 {
+		t.Errorf("Expected no garbage collection before GCInterval, but got %v", deleted)
+	}
+
+	store.mu.Lock()
+	store.lastGC = time.Now().Add(-DefaultRateLimitGCInterval)
+	store.mu.Unlock()
+	update()
+	update()
+
+	for deadline := time.Now().Add(time.Second); len(db.find("DELETE FROM")) == 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	if deleted := db.find(`DELETE FROM "rate_limits" WHERE expires_at < $1`); len(deleted) != 1 {
+		t.Errorf("Expected expired counters to be deleted once after GCInterval, but got %v", deleted)
+	}
+}
@@ -0,0 +1,189 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimitTokenBucket(t *testing.T) {
+	stack := &MiddlewareStack{}
+	stack.Use(RateLimit(RateLimitOptions{Limit: 1, Period: time.Hour, Burst: 2}))
+
+	handler := stack.MustApply(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+
+	for i, expected := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+
+		if recorder.Code != expected {
+			t.Errorf("Expected request %v to get %v, but got %v", i, expected, recorder.Code)
+		}
+
+		if recorder.Header().Get("RateLimit-Limit") != "2" {
+			t.Errorf("Expected RateLimit-Limit header, but got %v", recorder.Header())
+		}
+
+		if expected == http.StatusTooManyRequests && recorder.Header().Get("Retry-After") != "3600" {
+			t.Errorf("Expected Retry-After 3600, but got %v", recorder.Header().Get("Retry-After"))
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.2:1234"
+	handler.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK || recorder.Header().Get("RateLimit-Remaining") != "1" {
+		t.Errorf("Expected another client to be limited separately, but got %v %v", recorder.Code, recorder.Header())
+	}
+}
+
+func TestRateLimitSlidingWindow(t *testing.T) {
+	var (
+		period = time.Minute
+		start  = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		state  RateLimitState
+	)
+
+	for i := 0; i < 10; i++ {
+		if result := takeSlidingWindow(&state, start.Add(time.Second), 10, period); !result.Allowed || result.Remaining != 9-i {
+			t.Errorf("Expected request %v to be allowed, but got %+v", i, result)
+		}
+	}
+
+	if result := takeSlidingWindow(&state, start.Add(2*time.Second), 10, period); result.Allowed {
+		t.Errorf("Expected 11th request to be limited, but got %+v", result)
+	}
+
+	// a quarter into the next window, 75% of the previous window still counts
+	next := start.Add(period + period/4)
+	for i := 0; i < 2; i++ {
+		if result := takeSlidingWindow(&state, next, 10, period); !result.Allowed {
+			t.Errorf("Expected request %v of next window to be allowed, but got %+v", i, result)
+		}
+	}
+
+	if result := takeSlidingWindow(&state, next, 10, period); result.Allowed || ceilSeconds(result.RetryAfter) != 3 {
+		t.Errorf("Expected request to be limited until enough of previous window slides out, but got %+v", result)
+	}
+}
+
+func TestRateLimitKeyByHeader(t *testing.T) {
+	stack := &MiddlewareStack{}
+	stack.Use(RateLimit(RateLimitOptions{Algorithm: SlidingWindow, Limit: 1, Period: time.Hour, Key: KeyByHeader("X-API-Key")}))
+
+	handler := stack.MustApply(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+
+	for i, expected := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-API-Key", "key")
+		handler.ServeHTTP(recorder, req)
+
+		if recorder.Code != expected {
+			t.Errorf("Expected request %v to get %v, but got %v", i, expected, recorder.Code)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+	if recorder.Code != http.StatusOK || recorder.Header().Get("RateLimit-Limit") != "" {
+		t.Errorf("Expected requests without key not to be limited, but got %v %v", recorder.Code, recorder.Header())
+	}
+}
+
+func TestRateLimitKeyByIP(t *testing.T) {
+	stack := &MiddlewareStack{}
+	stack.Use(RateLimit(RateLimitOptions{Limit: 1, Period: time.Hour, Key: KeyByIP(1)}))
+
+	handler := stack.MustApply(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+
+	// the client sends its own X-Forwarded-For, the proxy appends the client's address
+	for i, spoofed := range []string{"", "1.1.1.1", "2.2.2.2, 3.3.3.3"} {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/", nil)
+		if spoofed != "" {
+			req.Header.Add("X-Forwarded-For", spoofed)
+		}
+		req.Header.Add("X-Forwarded-For", "203.0.113.7")
+		handler.ServeHTTP(recorder, req)
+
+		if expected := map[bool]int{true: http.StatusOK, false: http.StatusTooManyRequests}[i == 0]; recorder.Code != expected {
+			t.Errorf("Expected request %v with X-Forwarded-For %q to get %v, but got %v", i, spoofed, expected, recorder.Code)
+		}
+	}
+
+	for header, expected := range map[string]string{
+		"203.0.113.7":                        "203.0.113.7",
+		"1.1.1.1, 203.0.113.7, 198.51.100.2": "203.0.113.7",
+		"203.0.113.7,198.51.100.2":           "203.0.113.7",
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Forwarded-For", header)
+		if ip := remoteIP(req, 2); ip != expected {
+			t.Errorf("Expected client IP of %q behind 2 proxies to be %v, but got %v", header, expected, ip)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Forwarded-For", "1.1.1.1")
+	if ip := remoteIP(req, 0); ip != "192.0.2.1" {
+		t.Errorf("Expected X-Forwarded-For to be ignored without trusted proxies, but got %v", ip)
+	}
+}
+
+func TestRateLimitStoreSharedByRebuilds(t *testing.T) {
+	factory, _ := DefaultRegistry.Lookup(RateLimitName)
+	registry := &Registry{}
+	registry.Register(RateLimitName, factory)
+
+	config, err := ParseConfig([]byte(`middlewares: [{name: rate_limit, params: {limit: 1, period: 1h}}]`))
+	if err != nil {
+		t.Fatalf("Failed to parse config, got %v", err)
+	}
+
+	for i, expected := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		stack, err := registry.Build(config)
+		if err != nil {
+			t.Fatalf("Failed to build stack, got %v", err)
+		}
+
+		recorder := httptest.NewRecorder()
+		stack.MustApply(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})).ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+		if recorder.Code != expected {
+			t.Errorf("Expected request %v to get %v with counters kept by rebuilt stacks, but got %v", i, expected, recorder.Code)
+		}
+	}
+}
+
+func TestRateLimitInvalidOptions(t *testing.T) {
+	stack := &MiddlewareStack{}
+	stack.Use(RateLimit(RateLimitOptions{Period: time.Second}))
+
+	var invalidErr *InvalidMiddlewareError
+	if _, err := stack.Compile(http.NotFoundHandler()); !errors.As(err, &invalidErr) || invalidErr.Middleware != RateLimitName {
+		t.Errorf("Expected *InvalidMiddlewareError for rate_limit, but got %v", err)
+	}
+}
@@ -0,0 +1,207 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"mime"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// PanicReporter is called with panics recovered by Recover, e.g. to send them to an error tracker
+type PanicReporter func(req *http.Request, recovered interface{}, stack []byte)
+
+var (
+	panicReportersMu sync.RWMutex
+	panicReporters   []PanicReporter
+)
+
+// RegisterPanicReporter register reporter, it is called by every Recover middleware
+func RegisterPanicReporter(reporter PanicReporter) {
+	panicReportersMu.Lock()
+	defer panicReportersMu.Unlock()
+	panicReporters = append(panicReporters, reporter)
+}
+
+// RecoverOptions options of Recover
+type RecoverOptions struct {
+	// Logger is log.StandardLogger() if it is nil
+	Logger log.FieldLogger
+	// Message of the 500 response, "Internal Server Error" if it is blank
+	Message string
+	// Reporters are called after the registered panic reporters
+	Reporters []PanicReporter
+	// Render writes the response instead of the default one, which is JSON or
+	// HTML depending on the request's Accept header, or plain text
+	Render func(w http.ResponseWriter, req *http.Request, recovered interface{})
+}
+
+// Recover returns a middleware recovering from panics of later handlers, it
+// logs them with the stack trace, reports them and responds with 500. It is
+// declared to run before all other built-in middlewares
+func Recover(options RecoverOptions) Middleware {
+	var insertBefore []string
+	for _, name := range builtinNames {
+		if name != RecoverName {
+			insertBefore = append(insertBefore, name)
+		}
+	}
+
+	logger := options.Logger
+	if logger == nil {
+		logger = log.StandardLogger()
+	}
+
+	if options.Message == "" {
+		options.Message = http.StatusText(http.StatusInternalServerError)
+	}
+
+	if options.Render == nil {
+		options.Render = func(w http.ResponseWriter, req *http.Request, _ interface{}) {
+			RenderError(w, req, http.StatusInternalServerError, options.Message)
+		}
+	}
+
+	return Middleware{
+		Name:         RecoverName,
+		InsertBefore: insertBefore,
+		Handler: func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				recorder := newResponseRecorder(w)
+				ctx, requestID := contextWithRequestIDSlot(req.Context())
+
+				defer func() {
+					recovered := recover()
+					if recovered == nil {
+						return
+					}
+
+					if recovered == http.ErrAbortHandler {
+						// the server aborts the response on purpose, don't log it
+						panic(recovered)
+					}
+
+					stack := debug.Stack()
+					fields := log.Fields{
+						"panic":  fmt.Sprint(recovered),
+						"method": req.Method,
+						"path":   req.URL.Path,
+						"stack":  string(stack),
+					}
+					if id := recoveredRequestID(req, *requestID, recorder); id != "" {
+						fields["request_id"] = id
+					}
+					logger.WithFields(fields).Error("recovered from panic")
+
+					panicReportersMu.RLock()
+					reporters := append(append([]PanicReporter{}, panicReporters...), options.Reporters...)
+					panicReportersMu.RUnlock()
+					for _, reporter := range reporters {
+						reportPanic(logger, reporter, req, recovered, stack)
+					}
+
+					if !recorder.Written() {
+						options.Render(recorder, req, recovered)
+					}
+				}()
+
+				next.ServeHTTP(recorder, req.WithContext(ctx))
+			})
+		},
+	}
+}
+
+// recoveredRequestID returns the request ID of a panicking request, recorded
+// by the RequestID middleware running after Recover, whatever its header is
+func recoveredRequestID(req *http.Request, recorded string, recorder *responseRecorder) string {
+	if id := GetRequestID(req); id != "" {
+		return id
+	}
+
+	if recorded != "" {
+		return recorded
+	}
+	return recorder.Header().Get(RequestIDHeader)
+}
+
+// reportPanic calls reporter, a panicking reporter is logged instead of crashing the server
+func reportPanic(logger log.FieldLogger, reporter PanicReporter, req *http.Request, recovered interface{}, stack []byte) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.WithField("panic", fmt.Sprint(err)).Error("panic reporter panicked")
+		}
+	}()
+	reporter(req, recovered, stack)
+}
+
+// RenderError writes an error response as JSON or HTML depending on the Accept header of req, or as plain text
+func RenderError(w http.ResponseWriter, req *http.Request, status int, message string) {
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	switch acceptedType(req, "application/json", "text/html") {
+	case "application/json":
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": message, "status": status})
+	case "text/html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(status)
+		fmt.Fprintf(w, "<!DOCTYPE html>\n<html><head><title>%d %s</title></head><body><h1>%d %s</h1></body></html>\n", status, html.EscapeString(message), status, html.EscapeString(message))
+	default:
+		http.Error(w, message, status)
+	}
+}
+
+// acceptedType returns the first of types accepted by req, in the order of the Accept header
+func acceptedType(req *http.Request, types ...string) string {
+	for _, accept := range strings.Split(req.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+
+		for _, t := range types {
+			if mediaType == t || (strings.HasSuffix(mediaType, "/*") && strings.HasPrefix(t, strings.TrimSuffix(mediaType, "*"))) {
+				return t
+			}
+		}
+	}
+	return ""
+}
+
+func init() {
+	RegisterFactory(RecoverName, Factory{
+		Description: "recovers from panics, logs them and responds with 500",
+		Params: []Param{
+			{Name: "message", Type: StringParam},
+		},
+		New: func(params Params) (Middleware, error) {
+			return Recover(RecoverOptions{Message: params.String("message")}), nil
+		},
+	})
+}
@@ -0,0 +1,97 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestRecover(t *testing.T) {
+	var (
+		buf      = &bytes.Buffer{}
+		logger   = log.New()
+		reported interface{}
+		stack    = &MiddlewareStack{}
+	)
+	logger.Out = buf
+
+	stack.Use(AccessLog(AccessLogOptions{Logger: logger}))
+	stack.Use(Recover(RecoverOptions{Logger: logger, Reporters: []PanicReporter{func(req *http.Request, recovered interface{}, stack []byte) {
+		reported = recovered
+	}}}))
+
+	if str := stack.String(); str != "MiddlewareStack: recover, access_log" {
+		t.Errorf("Expected recover to run first, but got %v", str)
+	}
+
+	handler := stack.MustApply(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { panic("boom") }))
+
+	for accept, contentType := range map[string]string{"application/json": "application/json", "text/html,*/*;q=0.8": "text/html", "*/*": "text/plain"} {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept", accept)
+		handler.ServeHTTP(recorder, req)
+
+		if recorder.Code != http.StatusInternalServerError || !strings.HasPrefix(recorder.Header().Get("Content-Type"), contentType) {
+			t.Errorf("Expected %v 500 response for %v, but got %v %v", contentType, accept, recorder.Code, recorder.Header().Get("Content-Type"))
+		}
+	}
+
+	if reported != "boom" {
+		t.Errorf("Expected panic to be reported, but got %v", reported)
+	}
+
+	if !strings.Contains(buf.String(), "recovered from panic") || !strings.Contains(buf.String(), "recover_test.go") {
+		t.Errorf("Expected panic to be logged with stack trace, but got %v", buf)
+	}
+
+	if count := strings.Count(buf.String(), `msg="request panicked"`); count != 3 || !strings.Contains(buf.String(), "status=500") {
+		t.Errorf("Expected panicking requests to be access logged with status 500, but got %v", buf)
+	}
+}
+
+func TestRecoverRequestID(t *testing.T) {
+	var (
+		buf    = &bytes.Buffer{}
+		logger = log.New()
+		stack  = &MiddlewareStack{}
+	)
+	logger.Out = buf
+
+	stack.Use(RequestID(RequestIDOptions{Header: "X-Trace-ID"}))
+	stack.Use(Recover(RecoverOptions{Logger: logger}))
+
+	handler := stack.MustApply(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { panic("boom") }))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Trace-ID", "trace-1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if !strings.Contains(buf.String(), "request_id=trace-1") {
+		t.Errorf("Expected panic to be logged with the ID of the custom request ID header, but got %v", buf)
+	}
+}
@@ -0,0 +1,297 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ParamType type of a middleware factory's parameter
+type ParamType string
+
+// Parameter types supported by factories
+const (
+	StringParam     ParamType = "string"
+	IntParam        ParamType = "int"
+	FloatParam      ParamType = "float"
+	BoolParam       ParamType = "bool"
+	DurationParam   ParamType = "duration"
+	StringListParam ParamType = "[]string"
+)
+
+// Param schema of a middleware factory's parameter
+type Param struct {
+	Name        string
+	Type        ParamType
+	Required    bool
+	Default     interface{}
+	Description string
+}
+
+// Params parameters of a middleware, values are checked against the factory's
+// schema, so accessors return values of the declared type, or the zero value
+type Params map[string]interface{}
+
+// String returns string parameter name
+func (params Params) String(name string) string {
+	value, _ := params[name].(string)
+	return value
+}
+
+// Int returns int parameter name
+func (params Params) Int(name string) int {
+	value, _ := params[name].(int)
+	return value
+}
+
+// Float returns float parameter name
+func (params Params) Float(name string) float64 {
+	value, _ := params[name].(float64)
+	return value
+}
+
+// Bool returns bool parameter name
+func (params Params) Bool(name string) bool {
+	value, _ := params[name].(bool)
+	return value
+}
+
+// Duration returns duration parameter name
+func (params Params) Duration(name string) time.Duration {
+	value, _ := params[name].(time.Duration)
+	return value
+}
+
+// Strings returns string list parameter name
+func (params Params) Strings(name string) []string {
+	value, _ := params[name].([]string)
+	return value
+}
+
+// buildParam reserved parameter set by Registry.Build, it isn't a valid
+// parameter name of a configuration
+const buildParam = "\x00build"
+
+// buildScope the registry and middleware name of a build
+type buildScope struct {
+	registry *Registry
+	name     string
+}
+
+// Name returns the configured name of the middleware being built, it is blank
+// if the middleware isn't built by a Registry
+func (params Params) Name() string {
+	scope, _ := params[buildParam].(*buildScope)
+	if scope == nil {
+		return ""
+	}
+	return scope.name
+}
+
+// Shared returns the value shared under key by all builds of the registry,
+// create is called for the first build only, or every time if the middleware
+// isn't built by a Registry. Factories keep database handles and stores in
+// it, so rebuilding a stack, e.g. by ConfigWatcher, reuses them instead of
+// leaking connections and losing state, a failed create is retried next time
+func (params Params) Shared(key string, create func() (interface{}, error)) (interface{}, error) {
+	scope, _ := params[buildParam].(*buildScope)
+	if scope == nil {
+		return create()
+	}
+	return scope.registry.shared(key, create)
+}
+
+// Factory builds middlewares of a type from parameters
+type Factory struct {
+	Params      []Param
+	Description string
+	New         func(params Params) (Middleware, error)
+}
+
+// Registry middleware factories keyed by type name, it is safe for concurrent use
+type Registry struct {
+	mu        sync.RWMutex
+	factories map[string]Factory
+
+	sharedMu sync.Mutex
+	values   map[string]interface{}
+}
+
+// DefaultRegistry default middleware factories registry, built-in middlewares register themselves in it
+var DefaultRegistry = &Registry{}
+
+// RegisterFactory register factory as typeName with DefaultRegistry
+func RegisterFactory(typeName string, factory Factory) error {
+	return DefaultRegistry.Register(typeName, factory)
+}
+
+// Register register factory as typeName, returns an error if typeName is taken
+func (registry *Registry) Register(typeName string, factory Factory) error {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	if _, ok := registry.factories[typeName]; ok {
+		return fmt.Errorf("middleware factory %v is already registered", typeName)
+	}
+
+	if registry.factories == nil {
+		registry.factories = map[string]Factory{}
+	}
+	registry.factories[typeName] = factory
+	return nil
+}
+
+// Lookup returns factory registered as typeName
+func (registry *Registry) Lookup(typeName string) (Factory, bool) {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	factory, ok := registry.factories[typeName]
+	return factory, ok
+}
+
+// shared returns the value of key, creating it if it doesn't exist, see Params.Shared
+func (registry *Registry) shared(key string, create func() (interface{}, error)) (interface{}, error) {
+	registry.sharedMu.Lock()
+	defer registry.sharedMu.Unlock()
+
+	if value, ok := registry.values[key]; ok {
+		return value, nil
+	}
+
+	value, err := create()
+	if err != nil {
+		return nil, err
+	}
+
+	if registry.values == nil {
+		registry.values = map[string]interface{}{}
+	}
+	registry.values[key] = value
+	return value, nil
+}
+
+// Types returns sorted type names of registered factories
+func (registry *Registry) Types() []string {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	var types []string
+	for typeName := range registry.factories {
+		types = append(types, typeName)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// validate checks values against the factory's schema, and returns them
+// converted to the declared types with defaults filled in
+func (factory Factory) validate(values map[string]interface{}) (Params, []error) {
+	var (
+		errs   []error
+		params = Params{}
+		known  = map[string]bool{}
+	)
+
+	for _, param := range factory.Params {
+		known[param.Name] = true
+
+		value, ok := values[param.Name]
+		if !ok || value == nil {
+			if param.Required {
+				errs = append(errs, &ParamError{Param: param.Name, Err: fmt.Errorf("is required")})
+			} else if param.Default != nil {
+				params[param.Name] = param.Default
+			}
+			continue
+		}
+
+		converted, err := convertParam(param.Type, value)
+		if err != nil {
+			errs = append(errs, &ParamError{Param: param.Name, Err: err})
+			continue
+		}
+		params[param.Name] = converted
+	}
+
+	var unknown []string
+	for name := range values {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		errs = append(errs, &ParamError{Param: name, Err: fmt.Errorf("is unknown")})
+	}
+
+	return params, errs
+}
+
+// convertParam converts a decoded JSON value to paramType
+func convertParam(paramType ParamType, value interface{}) (interface{}, error) {
+	switch paramType {
+	case StringParam:
+		if str, ok := value.(string); ok {
+			return str, nil
+		}
+	case IntParam:
+		if number, ok := value.(float64); ok && number == math.Trunc(number) {
+			return int(number), nil
+		}
+	case FloatParam:
+		if number, ok := value.(float64); ok {
+			return number, nil
+		}
+	case BoolParam:
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+	case DurationParam:
+		if str, ok := value.(string); ok {
+			duration, err := time.ParseDuration(str)
+			if err != nil {
+				return nil, fmt.Errorf("is an invalid duration: %w", err)
+			}
+			return duration, nil
+		}
+	case StringListParam:
+		if values, ok := value.([]interface{}); ok {
+			strs := []string{}
+			for _, v := range values {
+				str, ok := v.(string)
+				if !ok {
+					return nil, fmt.Errorf("expects %v, but got %v", paramType, value)
+				}
+				strs = append(strs, str)
+			}
+			return strs, nil
+		}
+	default:
+		return nil, fmt.Errorf("has unsupported type %v", paramType)
+	}
+
+	return nil, fmt.Errorf("expects %v, but got %v", paramType, value)
+}
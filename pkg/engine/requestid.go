@@ -0,0 +1,232 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// RequestIDHeader default header of request IDs
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDMetadataKey metadata key of request IDs in gRPC calls
+const requestIDMetadataKey = "x-request-id"
+
+type requestIDKey struct{}
+
+// ContextWithRequestID returns a copy of ctx carrying request ID id
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request ID carried by ctx, or a blank string
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+type requestIDSlotKey struct{}
+
+// contextWithRequestIDSlot returns a copy of ctx with a slot the RequestID
+// middleware records the request ID in, for middlewares running before it,
+// like Recover, which don't see its request context
+func contextWithRequestIDSlot(ctx context.Context) (context.Context, *string) {
+	slot := new(string)
+	return context.WithValue(ctx, requestIDSlotKey{}, slot), slot
+}
+
+// GetRequestID returns the request ID of req, set by the RequestID middleware
+func GetRequestID(req *http.Request) string {
+	return RequestIDFromContext(req.Context())
+}
+
+// RequestIDOptions options of RequestID
+type RequestIDOptions struct {
+	// Header to read and echo the request ID, RequestIDHeader if it is blank
+	Header string
+	// Generator generates IDs of requests without a valid one, 32 random hex digits if it is nil
+	Generator func() string
+	// IgnoreIncoming always generates a new ID, e.g. for services facing untrusted clients
+	IgnoreIncoming bool
+}
+
+// RequestID returns a middleware that takes the request ID from the request
+// header, or generates one, stores it in the request context and echoes it in
+// the response header. Incoming IDs that are too long or contain anything but
+// printable ASCII are replaced, so they could be logged safely
+func RequestID(options RequestIDOptions) Middleware {
+	if options.Header == "" {
+		options.Header = RequestIDHeader
+	}
+
+	if options.Generator == nil {
+		options.Generator = NewRequestID
+	}
+
+	return Middleware{
+		Name:         RequestIDName,
+		InsertBefore: []string{AccessLogName},
+		Handler: func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				id := req.Header.Get(options.Header)
+				if options.IgnoreIncoming || !validRequestID(id) {
+					id = options.Generator()
+				}
+
+				w.Header().Set(options.Header, id)
+				if slot, ok := req.Context().Value(requestIDSlotKey{}).(*string); ok {
+					*slot = id
+				}
+				next.ServeHTTP(w, req.WithContext(ContextWithRequestID(req.Context(), id)))
+			})
+		},
+	}
+}
+
+// NewRequestID generates a random request ID
+func NewRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+
+	for _, r := range id {
+		if r < 0x21 || r > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// RequestIDRoundTripper returns a middleware of outbound HTTP requests
+// propagating the request ID of their context in RequestIDHeader
+func RequestIDRoundTripper() RoundTripperMiddleware {
+	return RoundTripperMiddleware{
+		Ordering: Ordering{Name: RequestIDName},
+		Handler: func(next http.RoundTripper) http.RoundTripper {
+			return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				if id := GetRequestID(req); id != "" && req.Header.Get(RequestIDHeader) == "" {
+					// round trippers must not modify the original request
+					req = req.Clone(req.Context())
+					req.Header.Set(RequestIDHeader, id)
+				}
+				return next.RoundTrip(req)
+			})
+		},
+	}
+}
+
+// RequestIDUnaryClientInterceptor returns a gRPC client interceptor propagating the request ID of the call's context
+func RequestIDUnaryClientInterceptor() UnaryClientInterceptor {
+	return UnaryClientInterceptor{
+		Ordering: Ordering{Name: RequestIDName},
+		Interceptor: func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			return invoker(outgoingRequestID(ctx), method, req, reply, cc, opts...)
+		},
+	}
+}
+
+// RequestIDStreamClientInterceptor returns a gRPC client interceptor propagating the request ID of the stream's context
+func RequestIDStreamClientInterceptor() StreamClientInterceptor {
+	return StreamClientInterceptor{
+		Ordering: Ordering{Name: RequestIDName},
+		Interceptor: func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			return streamer(outgoingRequestID(ctx), desc, cc, method, opts...)
+		},
+	}
+}
+
+// RequestIDUnaryInterceptor returns a gRPC server interceptor storing the
+// request ID of incoming metadata, or a generated one, in the call's context
+func RequestIDUnaryInterceptor() UnaryInterceptor {
+	return UnaryInterceptor{
+		Ordering: Ordering{Name: RequestIDName},
+		Interceptor: func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			return handler(incomingRequestID(ctx), req)
+		},
+	}
+}
+
+// RequestIDStreamInterceptor returns a gRPC server interceptor storing the
+// request ID of incoming metadata, or a generated one, in the stream's context
+func RequestIDStreamInterceptor() StreamInterceptor {
+	return StreamInterceptor{
+		Ordering: Ordering{Name: RequestIDName},
+		Interceptor: func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			return handler(srv, contextServerStream{ServerStream: stream, ctx: incomingRequestID(stream.Context())})
+		},
+	}
+}
+
+type contextServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (stream contextServerStream) Context() context.Context {
+	return stream.ctx
+}
+
+func outgoingRequestID(ctx context.Context) context.Context {
+	if id := RequestIDFromContext(ctx); id != "" {
+		if md, ok := metadata.FromOutgoingContext(ctx); !ok || len(md.Get(requestIDMetadataKey)) == 0 {
+			return metadata.AppendToOutgoingContext(ctx, requestIDMetadataKey, id)
+		}
+	}
+	return ctx
+}
+
+func incomingRequestID(ctx context.Context) context.Context {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(requestIDMetadataKey); len(ids) > 0 && validRequestID(ids[0]) {
+			return ContextWithRequestID(ctx, ids[0])
+		}
+	}
+	return ContextWithRequestID(ctx, NewRequestID())
+}
+
+func init() {
+	DefaultRoundTripperStack.Use(RequestIDRoundTripper())
+	DefaultUnaryClientInterceptorStack.Use(RequestIDUnaryClientInterceptor())
+	DefaultStreamClientInterceptorStack.Use(RequestIDStreamClientInterceptor())
+
+	RegisterFactory(RequestIDName, Factory{
+		Description: "reads or generates request IDs and stores them in the request context",
+		Params: []Param{
+			{Name: "header", Type: StringParam, Default: RequestIDHeader},
+			{Name: "ignore_incoming", Type: BoolParam},
+		},
+		New: func(params Params) (Middleware, error) {
+			return RequestID(RequestIDOptions{Header: params.String("header"), IgnoreIncoming: params.Bool("ignore_incoming")}), nil
+		},
+	})
+}
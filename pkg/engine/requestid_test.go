@@ -0,0 +1,102 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestRequestID(t *testing.T) {
+	var (
+		buf    = &bytes.Buffer{}
+		logger = log.New()
+		stack  = &MiddlewareStack{}
+		seen   string
+	)
+	logger.Out = buf
+	logger.Formatter = &log.JSONFormatter{}
+
+	stack.Use(AccessLog(AccessLogOptions{Logger: logger}))
+	stack.Use(RequestID(RequestIDOptions{}))
+	handler := stack.MustApply(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		seen = GetRequestID(req)
+	}))
+
+	for incoming, reused := range map[string]bool{"abc-123": true, "": false, "bad id\n": false} {
+		buf.Reset()
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set(RequestIDHeader, incoming)
+		handler.ServeHTTP(recorder, req)
+
+		if echoed := recorder.Header().Get(RequestIDHeader); echoed != seen || seen == "" || (seen == incoming) != reused {
+			t.Errorf("Unexpected request ID %q for incoming %q, echoed %q", seen, incoming, echoed)
+		}
+
+		if !bytes.Contains(buf.Bytes(), []byte(`"request_id":"`+seen+`"`)) {
+			t.Errorf("Expected request ID %v to be logged, but got %v", seen, buf)
+		}
+	}
+}
+
+func TestRequestIDPropagation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(req.Header.Get(RequestIDHeader)))
+	}))
+	defer server.Close()
+
+	client, err := Client()
+	if err != nil {
+		t.Fatalf("Failed to build client, got %v", err)
+	}
+
+	req, _ := http.NewRequestWithContext(ContextWithRequestID(context.Background(), "abc"), "GET", server.URL, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to send request, got %v", err)
+	}
+	defer resp.Body.Close()
+
+	var body bytes.Buffer
+	body.ReadFrom(resp.Body)
+	if body.String() != "abc" || req.Header.Get(RequestIDHeader) != "" {
+		t.Errorf("Expected request ID to be propagated to a copy of the request, but got %v", body.String())
+	}
+
+	interceptors, _ := DefaultUnaryClientInterceptorStack.Interceptors()
+	var ids []string
+	interceptors[0](ContextWithRequestID(context.Background(), "abc"), "/Service/Method", nil, nil, nil, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		ids = md.Get(requestIDMetadataKey)
+		return nil
+	})
+	if len(ids) != 1 || ids[0] != "abc" {
+		t.Errorf("Expected request ID to be added to outgoing metadata, but got %v", ids)
+	}
+}
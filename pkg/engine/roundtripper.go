@@ -0,0 +1,83 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"net/http"
+)
+
+// RoundTripperFunc adapter to use a function as http.RoundTripper
+type RoundTripperFunc func(*http.Request) (*http.Response, error)
+
+// RoundTrip calls fn(req)
+func (fn RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return fn(req)
+}
+
+// RoundTripperMiddleware middleware of outbound HTTP requests, it is ordered like Middleware
+type RoundTripperMiddleware struct {
+	Ordering
+	Handler func(http.RoundTripper) http.RoundTripper
+}
+
+// RoundTripperStack middlewares stack of outbound HTTP requests, middlewares
+// are sorted with the same rules as MiddlewareStack, the first one sees the
+// request first
+type RoundTripperStack struct {
+	orderedList
+}
+
+// Use use middleware, returns a *DuplicateMiddlewareError if its name is taken, unless ReplaceExisting is given
+func (stack *RoundTripperStack) Use(middleware RoundTripperMiddleware, options ...UseOption) error {
+	return stack.use(middleware.ordering(), middleware.Handler, options)
+}
+
+// Compile apply middlewares to transport, http.DefaultTransport is used if it is nil
+func (stack *RoundTripperStack) Compile(transport http.RoundTripper) (http.RoundTripper, error) {
+	sortedEntries, err := stack.sorted()
+	if err != nil {
+		return nil, err
+	}
+
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	for idx := len(sortedEntries) - 1; idx >= 0; idx-- {
+		if handler := sortedEntries[idx].value.(func(http.RoundTripper) http.RoundTripper); handler != nil {
+			transport = handler(transport)
+		}
+	}
+	return transport, nil
+}
+
+// Client returns a http.Client sending requests through the stack
+func (stack *RoundTripperStack) Client(transport http.RoundTripper) (*http.Client, error) {
+	compiledTransport, err := stack.Compile(transport)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: compiledTransport}, nil
+}
+
+func (stack *RoundTripperStack) String() string {
+	return stack.describe("RoundTripperStack")
+}
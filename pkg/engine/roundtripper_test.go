@@ -0,0 +1,71 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRoundTripperStack(t *testing.T) {
+	var (
+		stack = &RoundTripperStack{}
+		tag   = func(name string) func(http.RoundTripper) http.RoundTripper {
+			return func(next http.RoundTripper) http.RoundTripper {
+				return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+					req.Header.Add("X-Middleware", name)
+					return next.RoundTrip(req)
+				})
+			}
+		}
+	)
+
+	stack.Use(RoundTripperMiddleware{Ordering: Ordering{Name: "auth_token", InsertAfter: []string{"tracing"}}, Handler: tag("auth_token")})
+	stack.Use(RoundTripperMiddleware{Ordering: Ordering{Name: "retry", InsertBefore: []string{"tracing"}}, Handler: tag("retry")})
+	stack.Use(RoundTripperMiddleware{Ordering: Ordering{Name: "tracing"}, Handler: tag("tracing")})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, req.Header["X-Middleware"])
+	}))
+	defer server.Close()
+
+	client, err := stack.Client(nil)
+	if err != nil {
+		t.Fatalf("Failed to compile round trippers, got %v", err)
+	}
+
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to send request, got %v", err)
+	}
+	defer resp.Body.Close()
+
+	if body, _ := io.ReadAll(resp.Body); string(body) != "[retry tracing auth_token]" {
+		t.Errorf("Expected request to go through retry, tracing, auth_token, but got %s", body)
+	}
+
+	if str := stack.String(); str != "RoundTripperStack: retry, tracing, auth_token" {
+		t.Errorf("Expected round trippers retry, tracing, auth_token, but got %v", str)
+	}
+}
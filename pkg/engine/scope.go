@@ -0,0 +1,120 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"net"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// scope returns handler, the middleware wrapping next, if the middleware is
+// scoped, only matching requests go through handler, others go straight to next
+func (middleware *Middleware) scope(next, handler http.Handler) http.Handler {
+	if !middleware.scoped() {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if middleware.matches(req) {
+			handler.ServeHTTP(w, req)
+		} else {
+			next.ServeHTTP(w, req)
+		}
+	})
+}
+
+func (middleware *Middleware) scoped() bool {
+	return len(middleware.Paths) > 0 || len(middleware.Methods) > 0 || len(middleware.Hosts) > 0 || middleware.Match != nil || middleware.Enabled != nil
+}
+
+// matches returns true if req is in the middleware's scope
+func (middleware *Middleware) matches(req *http.Request) bool {
+	if middleware.Enabled != nil && !middleware.Enabled() {
+		return false
+	}
+
+	if len(middleware.Paths) > 0 && !matchAny(middleware.Paths, req.URL.Path, matchPathPrefix) {
+		return false
+	}
+
+	if len(middleware.Methods) > 0 && !matchAny(middleware.Methods, req.Method, strings.EqualFold) {
+		return false
+	}
+
+	if len(middleware.Hosts) > 0 && !matchAny(middleware.Hosts, requestHost(req), matchHost) {
+		return false
+	}
+
+	return middleware.Match == nil || middleware.Match(req)
+}
+
+func matchAny(patterns []string, value string, match func(value, pattern string) bool) bool {
+	for _, pattern := range patterns {
+		if match(value, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// CleanPath returns the canonical form of URL path p, like http.ServeMux does,
+// "." and ".." elements and repeated slashes are removed, a trailing slash is kept
+func CleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+
+	cleaned := path.Clean("/" + p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+// matchPathPrefix returns true if the cleaned p is prefix or under it,
+// whole segments are matched, so "/api" matches "/api/users" but not "/apiary"
+func matchPathPrefix(p, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" {
+		return true
+	}
+
+	p = path.Clean("/" + p)
+	return p == prefix || strings.HasPrefix(p, prefix+"/")
+}
+
+// matchHost matches host with pattern, "*.example.com" matches any subdomain of example.com
+func matchHost(host, pattern string) bool {
+	if strings.HasPrefix(pattern, "*.") {
+		return len(host) > len(pattern)-1 && strings.HasSuffix(strings.ToLower(host), strings.ToLower(pattern[1:]))
+	}
+	return strings.EqualFold(host, pattern)
+}
+
+// requestHost returns host of req without port
+func requestHost(req *http.Request) string {
+	if host, _, err := net.SplitHostPort(req.Host); err == nil {
+		return host
+	}
+	return req.Host
+}
@@ -0,0 +1,276 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// CSPNonce placeholder of the per-request nonce in a Content-Security-Policy
+const CSPNonce = "{nonce}"
+
+// DefaultContentSecurityPolicy Content-Security-Policy set by SecureHeaders by default
+const DefaultContentSecurityPolicy = "default-src 'self'; script-src 'self' " + CSPNonce + "; style-src 'self' " + CSPNonce + "; object-src 'none'; base-uri 'self'; frame-ancestors 'none'"
+
+// DefaultHSTSMaxAge max age of HSTS by default
+const DefaultHSTSMaxAge = 365 * 24 * time.Hour
+
+// maxCSPReportSize maximum size of CSP violation reports
+const maxCSPReportSize = 64 << 10
+
+// SecureHeadersOptions options of SecureHeaders, blank headers are set to their defaults
+type SecureHeadersOptions struct {
+	// HSTSMaxAge is DefaultHSTSMaxAge if it is zero, HSTS is disabled if it is negative
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+	HSTSPreload           bool
+	// FrameOptions DENY or SAMEORIGIN, DENY by default
+	FrameOptions string
+	// ReferrerPolicy is strict-origin-when-cross-origin by default
+	ReferrerPolicy string
+	// PermissionsPolicy denies camera, microphone and geolocation by default
+	PermissionsPolicy string
+	// ContentSecurityPolicy is DefaultContentSecurityPolicy if it is blank,
+	// CSPNonce in it is replaced by the request's nonce
+	ContentSecurityPolicy string
+	// CSPReportOnly sends the policy as Content-Security-Policy-Report-Only, to try it without enforcing
+	CSPReportOnly bool
+	// CSPReportURI where browsers report violations, see CSPReportHandler
+	CSPReportURI string
+	// TrustProxy trusts X-Forwarded-Proto to tell HTTPS requests, HSTS is only sent over HTTPS
+	TrustProxy bool
+}
+
+type cspNonceKey struct{}
+
+// ContextWithCSPNonce returns a copy of ctx carrying CSP nonce
+func ContextWithCSPNonce(ctx context.Context, nonce string) context.Context {
+	return context.WithValue(ctx, cspNonceKey{}, nonce)
+}
+
+// CSPNonceFromContext returns the CSP nonce carried by ctx, or a blank string
+func CSPNonceFromContext(ctx context.Context) string {
+	nonce, _ := ctx.Value(cspNonceKey{}).(string)
+	return nonce
+}
+
+// GetCSPNonce returns the CSP nonce of req set by SecureHeaders, for the nonce attributes of inline scripts and styles
+func GetCSPNonce(req *http.Request) string {
+	return CSPNonceFromContext(req.Context())
+}
+
+// SecureHeaders returns a middleware setting security headers, HSTS,
+// X-Content-Type-Options, X-Frame-Options, Referrer-Policy,
+// Permissions-Policy and Content-Security-Policy, with a new nonce per request
+func SecureHeaders(options SecureHeadersOptions) Middleware {
+	if options.HSTSMaxAge == 0 {
+		options.HSTSMaxAge = DefaultHSTSMaxAge
+	}
+
+	if options.FrameOptions == "" {
+		options.FrameOptions = "DENY"
+	}
+
+	if options.ReferrerPolicy == "" {
+		options.ReferrerPolicy = "strict-origin-when-cross-origin"
+	}
+
+	if options.PermissionsPolicy == "" {
+		options.PermissionsPolicy = "camera=(), microphone=(), geolocation=()"
+	}
+
+	if options.ContentSecurityPolicy == "" {
+		options.ContentSecurityPolicy = DefaultContentSecurityPolicy
+	}
+
+	policy := options.ContentSecurityPolicy
+	if options.CSPReportURI != "" {
+		policy += "; report-uri " + options.CSPReportURI
+	}
+
+	cspHeader := "Content-Security-Policy"
+	if options.CSPReportOnly {
+		cspHeader = "Content-Security-Policy-Report-Only"
+	}
+
+	hsts := fmt.Sprintf("max-age=%d", int64(options.HSTSMaxAge.Seconds()))
+	if options.HSTSIncludeSubdomains {
+		hsts += "; includeSubDomains"
+	}
+	if options.HSTSPreload {
+		hsts += "; preload"
+	}
+
+	return Middleware{
+		Name:         SecureHeadersName,
+		InsertAfter:  []string{RequestIDName},
+		InsertBefore: append([]string{RateLimitName}, authNames...),
+		Validate: func() error {
+			if options.FrameOptions != "DENY" && options.FrameOptions != "SAMEORIGIN" {
+				return fmt.Errorf("frame options %v should be DENY or SAMEORIGIN", options.FrameOptions)
+			}
+
+			if options.HSTSPreload && (!options.HSTSIncludeSubdomains || options.HSTSMaxAge < DefaultHSTSMaxAge) {
+				return errors.New("HSTS preload needs includeSubDomains and a max age of at least a year")
+			}
+			return nil
+		},
+		Handler: func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				header := w.Header()
+				if options.HSTSMaxAge > 0 && isHTTPS(req, options.TrustProxy) {
+					header.Set("Strict-Transport-Security", hsts)
+				}
+				header.Set("X-Content-Type-Options", "nosniff")
+				header.Set("X-Frame-Options", options.FrameOptions)
+				header.Set("Referrer-Policy", options.ReferrerPolicy)
+				header.Set("Permissions-Policy", options.PermissionsPolicy)
+
+				if strings.Contains(policy, CSPNonce) {
+					nonce := newCSPNonce()
+					header.Set(cspHeader, strings.ReplaceAll(policy, CSPNonce, "'nonce-"+nonce+"'"))
+					req = req.WithContext(ContextWithCSPNonce(req.Context(), nonce))
+				} else {
+					header.Set(cspHeader, policy)
+				}
+
+				next.ServeHTTP(w, req)
+			})
+		},
+	}
+}
+
+// newCSPNonce returns a new random nonce
+func newCSPNonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// isHTTPS returns true if req is made over HTTPS, as told by X-Forwarded-Proto if trustProxy is true
+func isHTTPS(req *http.Request, trustProxy bool) bool {
+	if req.TLS != nil {
+		return true
+	}
+	return trustProxy && strings.EqualFold(req.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+// CSPReportHandler returns a handler logging CSP violation reports, both
+// application/csp-report and application/reports+json, mount it at CSPReportURI
+func CSPReportHandler(logger log.FieldLogger) http.Handler {
+	if logger == nil {
+		logger = log.StandardLogger()
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			RenderError(w, req, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+			return
+		}
+
+		data, err := io.ReadAll(io.LimitReader(req.Body, maxCSPReportSize+1))
+		if err != nil || len(data) > maxCSPReportSize {
+			RenderError(w, req, http.StatusRequestEntityTooLarge, http.StatusText(http.StatusRequestEntityTooLarge))
+			return
+		}
+
+		reports, err := parseCSPReports(data)
+		if err != nil {
+			RenderError(w, req, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		for _, report := range reports {
+			logger.WithFields(log.Fields(report)).WithField(AccessLogRequestID, GetRequestID(req)).Warn("content security policy violation")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// parseCSPReports parses a report of the report-uri directive, or a list of reports of the Reporting API
+func parseCSPReports(data []byte) ([]map[string]interface{}, error) {
+	var legacy struct {
+		Report map[string]interface{} `json:"csp-report"`
+	}
+	if err := json.Unmarshal(data, &legacy); err == nil && legacy.Report != nil {
+		return []map[string]interface{}{legacy.Report}, nil
+	}
+
+	var reports []struct {
+		Type string                 `json:"type"`
+		Body map[string]interface{} `json:"body"`
+	}
+	if err := json.Unmarshal(data, &reports); err != nil {
+		return nil, errors.New("invalid CSP report")
+	}
+
+	var bodies []map[string]interface{}
+	for _, report := range reports {
+		if report.Type == "csp-violation" && report.Body != nil {
+			bodies = append(bodies, report.Body)
+		}
+	}
+	return bodies, nil
+}
+
+func init() {
+	RegisterFactory(SecureHeadersName, Factory{
+		Description: "sets HSTS, CSP and other security headers",
+		Params: []Param{
+			{Name: "hsts_max_age", Type: DurationParam},
+			{Name: "hsts_include_subdomains", Type: BoolParam},
+			{Name: "hsts_preload", Type: BoolParam},
+			{Name: "frame_options", Type: StringParam},
+			{Name: "referrer_policy", Type: StringParam},
+			{Name: "permissions_policy", Type: StringParam},
+			{Name: "content_security_policy", Type: StringParam, Description: CSPNonce + " is replaced by the request's nonce"},
+			{Name: "csp_report_only", Type: BoolParam},
+			{Name: "csp_report_uri", Type: StringParam},
+			{Name: "trust_proxy", Type: BoolParam},
+		},
+		New: func(params Params) (Middleware, error) {
+			return SecureHeaders(SecureHeadersOptions{
+				HSTSMaxAge:            params.Duration("hsts_max_age"),
+				HSTSIncludeSubdomains: params.Bool("hsts_include_subdomains"),
+				HSTSPreload:           params.Bool("hsts_preload"),
+				FrameOptions:          params.String("frame_options"),
+				ReferrerPolicy:        params.String("referrer_policy"),
+				PermissionsPolicy:     params.String("permissions_policy"),
+				ContentSecurityPolicy: params.String("content_security_policy"),
+				CSPReportOnly:         params.Bool("csp_report_only"),
+				CSPReportURI:          params.String("csp_report_uri"),
+				TrustProxy:            params.Bool("trust_proxy"),
+			}), nil
+		},
+	})
+}
@@ -0,0 +1,121 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestSecureHeaders(t *testing.T) {
+	stack := &MiddlewareStack{}
+	stack.Use(SecureHeaders(SecureHeadersOptions{HSTSIncludeSubdomains: true, CSPReportURI: "/csp-report"}))
+
+	var nonce string
+	handler := stack.MustApply(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		nonce = GetCSPNonce(req)
+	}))
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil)
+	req.TLS = &tls.ConnectionState{}
+	handler.ServeHTTP(recorder, req)
+
+	for name, value := range map[string]string{
+		"Strict-Transport-Security": "max-age=31536000; includeSubDomains",
+		"X-Content-Type-Options":    "nosniff",
+		"X-Frame-Options":           "DENY",
+		"Referrer-Policy":           "strict-origin-when-cross-origin",
+	} {
+		if recorder.Header().Get(name) != value {
+			t.Errorf("Expected %v: %v, but got %v", name, value, recorder.Header().Get(name))
+		}
+	}
+
+	csp := recorder.Header().Get("Content-Security-Policy")
+	if nonce == "" || !strings.Contains(csp, "script-src 'self' 'nonce-"+nonce+"'") || !strings.HasSuffix(csp, "; report-uri /csp-report") {
+		t.Errorf("Expected CSP with nonce %v, but got %v", nonce, csp)
+	}
+
+	previous := nonce
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+	if nonce == previous || recorder.Header().Get("Strict-Transport-Security") != "" {
+		t.Errorf("Expected a new nonce and no HSTS over HTTP, but got %v %v", nonce, recorder.Header())
+	}
+}
+
+func TestSecureHeadersReportOnly(t *testing.T) {
+	stack := &MiddlewareStack{}
+	stack.Use(SecureHeaders(SecureHeadersOptions{ContentSecurityPolicy: "default-src 'self'", CSPReportOnly: true}))
+
+	recorder := httptest.NewRecorder()
+	stack.MustApply(http.NotFoundHandler()).ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+
+	if recorder.Header().Get("Content-Security-Policy") != "" || recorder.Header().Get("Content-Security-Policy-Report-Only") != "default-src 'self'" {
+		t.Errorf("Expected report only CSP, but got %v", recorder.Header())
+	}
+}
+
+func TestSecureHeadersInvalidOptions(t *testing.T) {
+	for _, options := range []SecureHeadersOptions{{FrameOptions: "ALLOW-FROM https://example.com"}, {HSTSPreload: true}} {
+		stack := &MiddlewareStack{}
+		stack.Use(SecureHeaders(options))
+
+		var invalidErr *InvalidMiddlewareError
+		if _, err := stack.Compile(http.NotFoundHandler()); !errors.As(err, &invalidErr) {
+			t.Errorf("Expected %+v to be invalid, but got %v", options, err)
+		}
+	}
+}
+
+func TestCSPReportHandler(t *testing.T) {
+	var (
+		buf    = &bytes.Buffer{}
+		logger = log.New()
+	)
+	logger.Out = buf
+
+	handler := CSPReportHandler(logger)
+	for body, status := range map[string]int{
+		`{"csp-report":{"blocked-uri":"https://evil.com/a.js","violated-directive":"script-src"}}`: http.StatusNoContent,
+		`[{"type":"csp-violation","body":{"blockedURL":"https://evil.com/b.js"}}]`:                 http.StatusNoContent,
+		`not json`: http.StatusBadRequest,
+	} {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/csp-report", strings.NewReader(body)))
+
+		if recorder.Code != status {
+			t.Errorf("Expected %v for report %v, but got %v", status, body, recorder.Code)
+		}
+	}
+
+	if !strings.Contains(buf.String(), "https://evil.com/a.js") || !strings.Contains(buf.String(), "https://evil.com/b.js") {
+		t.Errorf("Expected violations to be logged, but got %v", buf)
+	}
+}
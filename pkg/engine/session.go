@@ -0,0 +1,601 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Defaults of SessionOptions
+const (
+	DefaultSessionCookieName      = "session"
+	DefaultSessionIdleTimeout     = 30 * time.Minute
+	DefaultSessionAbsoluteTimeout = 24 * time.Hour
+)
+
+// sessionTouchInterval unmodified sessions are saved at most once per
+// interval to extend their idle timeout
+const sessionTouchInterval = time.Minute
+
+// SessionData session of a request, its values are stored as JSON, so
+// numbers are read back as float64. It is safe for concurrent use
+type SessionData struct {
+	mu        sync.Mutex
+	id        string
+	values    map[string]interface{}
+	createdAt time.Time
+	lastSeen  time.Time
+
+	isNew     bool
+	modified  bool
+	renewed   bool
+	destroyed bool
+}
+
+// NewSessionData returns a new empty session with a random ID, for SessionStore implementations
+func NewSessionData() *SessionData {
+	now := time.Now()
+	return &SessionData{id: newSessionID(), values: map[string]interface{}{}, createdAt: now, lastSeen: now, isNew: true}
+}
+
+// newSessionID returns a new random session ID
+func newSessionID() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ID returns the session ID, it changes when the session is renewed
+func (session *SessionData) ID() string {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return session.id
+}
+
+// CreatedAt returns when the session is created
+func (session *SessionData) CreatedAt() time.Time {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return session.createdAt
+}
+
+// IsNew returns true if the session is created by the current request
+func (session *SessionData) IsNew() bool {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return session.isNew
+}
+
+// Get returns value key, or nil
+func (session *SessionData) Get(key string) interface{} {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return session.values[key]
+}
+
+// GetString returns string value key, or a blank string
+func (session *SessionData) GetString(key string) string {
+	value, _ := session.Get(key).(string)
+	return value
+}
+
+// Set sets value key, it should be serializable as JSON
+func (session *SessionData) Set(key string, value interface{}) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	session.values[key] = value
+	session.modified = true
+}
+
+// Delete deletes value key
+func (session *SessionData) Delete(key string) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if _, ok := session.values[key]; ok {
+		delete(session.values, key)
+		session.modified = true
+	}
+}
+
+// Pop returns value key and deletes it, like a flash message
+func (session *SessionData) Pop(key string) interface{} {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	value, ok := session.values[key]
+	if ok {
+		delete(session.values, key)
+		session.modified = true
+	}
+	return value
+}
+
+// Renew gives the session a new ID, keeping its values, the old one is
+// deleted. Call it when the privilege changes, like logging in or out, to prevent session fixation
+func (session *SessionData) Renew() {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	session.id = newSessionID()
+	session.renewed = true
+	session.modified = true
+}
+
+// Destroy deletes the session and its cookie
+func (session *SessionData) Destroy() {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	session.values = map[string]interface{}{}
+	session.destroyed = true
+}
+
+// sessionRecord serialized session
+type sessionRecord struct {
+	ID        string                 `json:"id"`
+	Values    map[string]interface{} `json:"values"`
+	CreatedAt time.Time              `json:"created_at"`
+	LastSeen  time.Time              `json:"last_seen"`
+	ExpiresAt time.Time              `json:"expires_at"`
+}
+
+// encode returns session serialized as JSON
+func (session *SessionData) encode(expiresAt time.Time) ([]byte, error) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return json.Marshal(sessionRecord{ID: session.id, Values: session.values, CreatedAt: session.createdAt, LastSeen: session.lastSeen, ExpiresAt: expiresAt})
+}
+
+// decodeSession returns the session serialized as JSON in data, or nil if it is expired
+func decodeSession(data []byte) (*SessionData, error) {
+	var record sessionRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("invalid session: %w", err)
+	}
+
+	if time.Now().After(record.ExpiresAt) {
+		return nil, nil
+	}
+
+	if record.Values == nil {
+		record.Values = map[string]interface{}{}
+	}
+	return &SessionData{id: record.ID, values: record.Values, createdAt: record.CreatedAt, lastSeen: record.LastSeen}, nil
+}
+
+// SessionStore stores sessions, the cookie of a session holds the value returned by Save
+type SessionStore interface {
+	// Load returns the session of a cookie value, or nil if it doesn't exist or is expired
+	Load(ctx context.Context, value string) (*SessionData, error)
+	// Save saves session until expiresAt, returns the value of its cookie
+	Save(ctx context.Context, session *SessionData, expiresAt time.Time) (string, error)
+	// Delete deletes the session of a cookie value
+	Delete(ctx context.Context, value string) error
+}
+
+type sessionKey struct{}
+
+// ContextWithSession returns a copy of ctx carrying session
+func ContextWithSession(ctx context.Context, session *SessionData) context.Context {
+	return context.WithValue(ctx, sessionKey{}, session)
+}
+
+// SessionFromContext returns the session carried by ctx, or nil
+func SessionFromContext(ctx context.Context) *SessionData {
+	session, _ := ctx.Value(sessionKey{}).(*SessionData)
+	return session
+}
+
+// GetSession returns the session of req set by the Session middleware
+func GetSession(req *http.Request) *SessionData {
+	return SessionFromContext(req.Context())
+}
+
+// SessionOptions options of Session
+type SessionOptions struct {
+	Store SessionStore
+
+	// CookieName is DefaultSessionCookieName if it is blank
+	CookieName   string
+	CookieDomain string
+	// CookiePath is "/" if it is blank
+	CookiePath string
+	// SameSite is http.SameSiteLaxMode if it is zero
+	SameSite http.SameSite
+	// TrustProxy trusts X-Forwarded-Proto to tell HTTPS requests, cookies are secure over HTTPS
+	TrustProxy bool
+
+	// IdleTimeout expires sessions not used for it, DefaultSessionIdleTimeout if it is zero
+	IdleTimeout time.Duration
+	// AbsoluteTimeout expires sessions created before it, DefaultSessionAbsoluteTimeout if it is zero
+	AbsoluteTimeout time.Duration
+
+	// Logger is log.StandardLogger() if it is nil
+	Logger log.FieldLogger
+}
+
+// Session returns a middleware loading the session of requests from
+// options.Store, new sessions are only saved once they have values. Changes
+// are saved before the response header is written
+func Session(options SessionOptions) Middleware {
+	if options.CookieName == "" {
+		options.CookieName = DefaultSessionCookieName
+	}
+
+	if options.CookiePath == "" {
+		options.CookiePath = "/"
+	}
+
+	if options.SameSite == 0 {
+		options.SameSite = http.SameSiteLaxMode
+	}
+
+	if options.IdleTimeout == 0 {
+		options.IdleTimeout = DefaultSessionIdleTimeout
+	}
+
+	if options.AbsoluteTimeout == 0 {
+		options.AbsoluteTimeout = DefaultSessionAbsoluteTimeout
+	}
+
+	if options.Logger == nil {
+		options.Logger = log.StandardLogger()
+	}
+
+	return Middleware{
+		Name:         SessionName,
+		InsertAfter:  []string{RequestIDName, AccessLogName},
+		InsertBefore: []string{CSRFName},
+		Validate: func() error {
+			if options.Store == nil {
+				return errors.New("no session store")
+			}
+
+			if options.IdleTimeout < 0 || options.AbsoluteTimeout < options.IdleTimeout {
+				return fmt.Errorf("idle timeout %v should be positive and not exceed absolute timeout %v", options.IdleTimeout, options.AbsoluteTimeout)
+			}
+			return nil
+		},
+		Handler: func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				var (
+					cookieValue string
+					session     *SessionData
+				)
+
+				if cookie, err := req.Cookie(options.CookieName); err == nil && cookie.Value != "" {
+					cookieValue = cookie.Value
+					loaded, err := options.Store.Load(req.Context(), cookieValue)
+					if err != nil {
+						options.Logger.WithError(err).WithField(AccessLogRequestID, GetRequestID(req)).Error("cannot load session")
+					} else if loaded != nil && !options.expired(loaded, time.Now()) {
+						session = loaded
+					}
+				}
+
+				if session == nil {
+					session = NewSessionData()
+				}
+
+				writer := &sessionWriter{ResponseWriter: w, req: req, session: session, cookieValue: cookieValue, options: &options}
+				defer writer.commit()
+
+				next.ServeHTTP(writer, req.WithContext(ContextWithSession(req.Context(), session)))
+			})
+		},
+	}
+}
+
+// expired returns true if session is expired at now
+func (options *SessionOptions) expired(session *SessionData, now time.Time) bool {
+	return now.After(session.lastSeen.Add(options.IdleTimeout)) || now.After(session.createdAt.Add(options.AbsoluteTimeout))
+}
+
+// expiresAt returns when session expires if it isn't used again after now
+func (options *SessionOptions) expiresAt(session *SessionData, now time.Time) time.Time {
+	expiresAt := now.Add(options.IdleTimeout)
+	if absolute := session.createdAt.Add(options.AbsoluteTimeout); absolute.Before(expiresAt) {
+		return absolute
+	}
+	return expiresAt
+}
+
+// sessionWriter saves the session before the response header is written
+type sessionWriter struct {
+	http.ResponseWriter
+	req         *http.Request
+	session     *SessionData
+	cookieValue string
+	options     *SessionOptions
+	committed   bool
+}
+
+// commit saves or deletes the session if needed, and sets its cookie
+func (sw *sessionWriter) commit() {
+	if sw.committed {
+		return
+	}
+	sw.committed = true
+
+	var (
+		session = sw.session
+		store   = sw.options.Store
+		ctx     = sw.req.Context()
+		now     = time.Now()
+		logger  = sw.options.Logger.WithField(AccessLogRequestID, GetRequestID(sw.req))
+	)
+
+	session.mu.Lock()
+	var (
+		destroyed = session.destroyed
+		renewed   = session.renewed
+		empty     = len(session.values) == 0
+		save      = session.modified || now.Sub(session.lastSeen) >= sessionTouchInterval
+	)
+	session.mu.Unlock()
+
+	if (destroyed || renewed) && sw.cookieValue != "" {
+		if err := store.Delete(ctx, sw.cookieValue); err != nil {
+			logger.WithError(err).Error("cannot delete session")
+		}
+	}
+
+	if destroyed || (empty && session.IsNew()) {
+		if sw.cookieValue != "" {
+			sw.setCookie("", time.Unix(0, 0))
+		}
+		return
+	}
+
+	if !save {
+		return
+	}
+
+	session.mu.Lock()
+	session.lastSeen = now
+	session.mu.Unlock()
+
+	expiresAt := sw.options.expiresAt(session, now)
+	value, err := store.Save(ctx, session, expiresAt)
+	if err != nil {
+		logger.WithError(err).Error("cannot save session")
+		return
+	}
+
+	if value != sw.cookieValue {
+		sw.setCookie(value, expiresAt)
+	}
+}
+
+func (sw *sessionWriter) setCookie(value string, expiresAt time.Time) {
+	cookie := &http.Cookie{
+		Name:     sw.options.CookieName,
+		Value:    value,
+		Path:     sw.options.CookiePath,
+		Domain:   sw.options.CookieDomain,
+		Expires:  expiresAt,
+		Secure:   isHTTPS(sw.req, sw.options.TrustProxy),
+		HttpOnly: true,
+		SameSite: sw.options.SameSite,
+	}
+	if value == "" {
+		cookie.MaxAge = -1
+	}
+	http.SetCookie(sw.ResponseWriter, cookie)
+}
+
+func (sw *sessionWriter) WriteHeader(status int) {
+	sw.commit()
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *sessionWriter) Write(b []byte) (int, error) {
+	sw.commit()
+	return sw.ResponseWriter.Write(b)
+}
+
+// Flush flushes the wrapped writer if it is a http.Flusher
+func (sw *sessionWriter) Flush() {
+	if flusher, ok := sw.ResponseWriter.(http.Flusher); ok {
+		sw.commit()
+		flusher.Flush()
+	}
+}
+
+// Hijack hijacks the wrapped writer's connection if it is a http.Hijacker
+func (sw *sessionWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := sw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T doesn't support hijacking", sw.ResponseWriter)
+	}
+	sw.commit()
+	return hijacker.Hijack()
+}
+
+// Unwrap returns the wrapped writer, for http.ResponseController
+func (sw *sessionWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+func init() {
+	RegisterFactory(SessionName, Factory{
+		Description: "loads and saves sessions in cookies, memory or PostgreSQL",
+		Params: []Param{
+			{Name: "store", Type: StringParam, Default: "cookie", Description: "cookie, memory or postgres"},
+			{Name: "hash_key", Type: StringParam, Description: "signs cookie sessions, at least 32 bytes"},
+			{Name: "block_key", Type: StringParam, Description: "encrypts cookie sessions with AES, 16, 24 or 32 bytes"},
+			{Name: "postgres_dsn", Type: StringParam},
+			{Name: "postgres_table", Type: StringParam, Default: "sessions"},
+			{Name: "cookie_name", Type: StringParam},
+			{Name: "cookie_domain", Type: StringParam},
+			{Name: "cookie_path", Type: StringParam},
+			{Name: "idle_timeout", Type: DurationParam},
+			{Name: "absolute_timeout", Type: DurationParam},
+			{Name: "trust_proxy", Type: BoolParam},
+		},
+		New: func(params Params) (Middleware, error) {
+			options := SessionOptions{
+				CookieName:      params.String("cookie_name"),
+				CookieDomain:    params.String("cookie_domain"),
+				CookiePath:      params.String("cookie_path"),
+				IdleTimeout:     params.Duration("idle_timeout"),
+				AbsoluteTimeout: params.Duration("absolute_timeout"),
+				TrustProxy:      params.Bool("trust_proxy"),
+			}
+
+			switch store := params.String("store"); store {
+			case "cookie":
+				cookieStore, err := NewCookieSessionStore([]byte(params.String("hash_key")), []byte(params.String("block_key")))
+				if err != nil {
+					return Middleware{}, err
+				}
+				options.Store = cookieStore
+			case "memory", "postgres":
+				sharedStore, err := sharedSessionStore(params, store)
+				if err != nil {
+					return Middleware{}, err
+				}
+				options.Store = sharedStore
+			default:
+				return Middleware{}, fmt.Errorf("unknown session store %v", store)
+			}
+
+			return Session(options), nil
+		},
+	})
+}
+
+// sharedSessionStore returns the memory or postgres store of the session built
+// from params, it is shared by rebuilds of the stack, so config reloads keep sessions
+func sharedSessionStore(params Params, kind string) (SessionStore, error) {
+	if kind == "memory" {
+		store, _ := params.Shared(fmt.Sprintf("%v:memory:%v", SessionName, params.Name()), func() (interface{}, error) {
+			return NewMemorySessionStore(), nil
+		})
+		return store.(SessionStore), nil
+	}
+
+	dsn := params.String("postgres_dsn")
+	db, err := sharedPostgres(params, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	table := params.String("postgres_table")
+	store, err := params.Shared(fmt.Sprintf("%v:postgres:%v:%v", SessionName, dsn, table), func() (interface{}, error) {
+		store := NewPostgresSessionStore(db, table)
+		ctx, cancel := context.WithTimeout(context.Background(), postgresTimeout)
+		defer cancel()
+		if err := store.CreateTable(ctx); err != nil {
+			return nil, fmt.Errorf("cannot create session table %v: %w", table, err)
+		}
+		return store, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return store.(SessionStore), nil
+}
+
+// MemorySessionStore in memory SessionStore, sessions are only shared by the
+// current process and lost when it exits
+type MemorySessionStore struct {
+	mu        sync.Mutex
+	entries   map[string]memorySessionEntry
+	lastSweep time.Time
+}
+
+type memorySessionEntry struct {
+	data      []byte
+	expiresAt time.Time
+}
+
+// NewMemorySessionStore returns a new in memory SessionStore
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{entries: map[string]memorySessionEntry{}, lastSweep: time.Now()}
+}
+
+// Load returns the session of ID value
+func (store *MemorySessionStore) Load(_ context.Context, value string) (*SessionData, error) {
+	store.mu.Lock()
+	entry, ok := store.entries[value]
+	store.mu.Unlock()
+
+	if !ok || time.Now().After(entry.expiresAt) {
+		return nil, nil
+	}
+	return decodeSession(entry.data)
+}
+
+// Save saves session, its cookie holds the session ID
+func (store *MemorySessionStore) Save(_ context.Context, session *SessionData, expiresAt time.Time) (string, error) {
+	data, err := session.encode(expiresAt)
+	if err != nil {
+		return "", err
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if now := time.Now(); now.Sub(store.lastSweep) > time.Minute {
+		store.deleteExpired(now)
+		store.lastSweep = now
+	}
+
+	id := session.ID()
+	store.entries[id] = memorySessionEntry{data: data, expiresAt: expiresAt}
+	return id, nil
+}
+
+// Delete deletes the session of ID value
+func (store *MemorySessionStore) Delete(_ context.Context, value string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	delete(store.entries, value)
+	return nil
+}
+
+// DeleteExpired deletes expired sessions, returns count of deleted sessions
+func (store *MemorySessionStore) DeleteExpired() int {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.deleteExpired(time.Now())
+}
+
+func (store *MemorySessionStore) deleteExpired(now time.Time) int {
+	var count int
+	for id, entry := range store.entries {
+		if now.After(entry.expiresAt) {
+			delete(store.entries, id)
+			count++
+		}
+	}
+	return count
+}
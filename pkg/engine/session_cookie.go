@@ -0,0 +1,135 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// maxSessionCookieSize maximum size of a cookie value browsers keep
+const maxSessionCookieSize = 4000
+
+// minSessionHashKeyLength minimum length of hash keys, as long as the hash
+const minSessionHashKeyLength = 32
+
+// CookieSessionStore SessionStore keeping sessions in their cookies, they are
+// signed with HMAC-SHA256, and encrypted with AES-GCM if it has a block key.
+// Sessions can't be revoked before they expire, and must fit in a cookie
+type CookieSessionStore struct {
+	hashKey []byte
+	aead    cipher.AEAD
+}
+
+// NewCookieSessionStore returns a store signing sessions with hashKey, of at
+// least 32 bytes, and encrypting them with blockKey, of 16, 24 or 32 bytes,
+// if it isn't empty
+func NewCookieSessionStore(hashKey, blockKey []byte) (*CookieSessionStore, error) {
+	if len(hashKey) < minSessionHashKeyLength {
+		return nil, fmt.Errorf("hash key should be at least %v bytes", minSessionHashKeyLength)
+	}
+
+	store := &CookieSessionStore{hashKey: hashKey}
+	if len(blockKey) > 0 {
+		block, err := aes.NewCipher(blockKey)
+		if err != nil {
+			return nil, err
+		}
+
+		if store.aead, err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+	return store, nil
+}
+
+// Load returns the session of a cookie value, or nil if it is expired or its signature is invalid
+func (store *CookieSessionStore) Load(_ context.Context, value string) (*SessionData, error) {
+	idx := strings.LastIndex(value, ".")
+	if idx < 0 {
+		return nil, nil
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(value[idx+1:])
+	if err != nil || !hmac.Equal(signature, store.sign(value[:idx])) {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(value[:idx])
+	if err != nil {
+		return nil, nil
+	}
+
+	if store.aead != nil {
+		nonceSize := store.aead.NonceSize()
+		if len(data) < nonceSize {
+			return nil, nil
+		}
+
+		if data, err = store.aead.Open(nil, data[:nonceSize], data[nonceSize:], nil); err != nil {
+			return nil, nil
+		}
+	}
+	return decodeSession(data)
+}
+
+// Save returns session encoded as a cookie value
+func (store *CookieSessionStore) Save(_ context.Context, session *SessionData, expiresAt time.Time) (string, error) {
+	data, err := session.encode(expiresAt)
+	if err != nil {
+		return "", err
+	}
+
+	if store.aead != nil {
+		nonce := make([]byte, store.aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return "", err
+		}
+		data = store.aead.Seal(nonce, nonce, data, nil)
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	value := payload + "." + base64.RawURLEncoding.EncodeToString(store.sign(payload))
+	if len(value) > maxSessionCookieSize {
+		return "", errors.New("session is too large for a cookie")
+	}
+	return value, nil
+}
+
+// Delete does nothing, the cookie is deleted by the Session middleware
+func (store *CookieSessionStore) Delete(context.Context, string) error {
+	return nil
+}
+
+func (store *CookieSessionStore) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, store.hashKey)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
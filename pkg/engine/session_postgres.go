@@ -0,0 +1,126 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// DefaultSessionGCInterval how often PostgresSessionStore deletes expired sessions by default
+const DefaultSessionGCInterval = 10 * time.Minute
+
+// PostgresSessionStore SessionStore keeping sessions in a PostgreSQL table,
+// so they are shared by all replicas of a service. Expired rows are deleted
+// every GCInterval while saving sessions, or by calling DeleteExpired
+type PostgresSessionStore struct {
+	// GCInterval is DefaultSessionGCInterval if it is zero, expired rows are only deleted by DeleteExpired if it is negative
+	GCInterval time.Duration
+
+	db     *sql.DB
+	table  string
+	mu     sync.Mutex
+	lastGC time.Time
+}
+
+// NewPostgresSessionStore returns a store keeping sessions in table of db, see CreateTable
+func NewPostgresSessionStore(db *sql.DB, table string) *PostgresSessionStore {
+	return &PostgresSessionStore{db: db, table: pq.QuoteIdentifier(table), lastGC: time.Now()}
+}
+
+// CreateTable creates the store's table if it doesn't exist
+func (store *PostgresSessionStore) CreateTable(ctx context.Context) error {
+	_, err := store.db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	id TEXT PRIMARY KEY,
+	data BYTEA NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL
+)`, store.table))
+	return err
+}
+
+// Load returns the session of ID value
+func (store *PostgresSessionStore) Load(ctx context.Context, value string) (*SessionData, error) {
+	var data []byte
+	err := store.db.QueryRowContext(ctx, fmt.Sprintf(`SELECT data FROM %s WHERE id = $1 AND expires_at > $2`, store.table), value, time.Now()).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return decodeSession(data)
+}
+
+// Save saves session, its cookie holds the session ID
+func (store *PostgresSessionStore) Save(ctx context.Context, session *SessionData, expiresAt time.Time) (string, error) {
+	data, err := session.encode(expiresAt)
+	if err != nil {
+		return "", err
+	}
+
+	id := session.ID()
+	if _, err := store.db.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (id, data, expires_at) VALUES ($1, $2, $3)
+ON CONFLICT (id) DO UPDATE SET data = EXCLUDED.data, expires_at = EXCLUDED.expires_at`, store.table), id, data, expiresAt); err != nil {
+		return "", err
+	}
+
+	store.collectGarbage()
+	return id, nil
+}
+
+// Delete deletes the session of ID value
+func (store *PostgresSessionStore) Delete(ctx context.Context, value string) error {
+	_, err := store.db.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE id = $1`, store.table), value)
+	return err
+}
+
+// DeleteExpired deletes expired sessions, returns count of deleted rows
+func (store *PostgresSessionStore) DeleteExpired(ctx context.Context) (int64, error) {
+	result, err := store.db.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE expires_at <= $1`, store.table), time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// collectGarbage deletes expired sessions in the background if GCInterval passed since the last time
+func (store *PostgresSessionStore) collectGarbage() {
+	interval := store.GCInterval
+	if interval == 0 {
+		interval = DefaultSessionGCInterval
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if interval < 0 || time.Since(store.lastGC) < interval {
+		return
+	}
+	store.lastGC = time.Now()
+
+	go store.DeleteExpired(context.Background())
+}
@@ -0,0 +1,91 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPostgresSessionStore(t *testing.T) {
+	var (
+		db        = &stubDB{}
+		store     = NewPostgresSessionStore(db.open(), "sessions")
+		ctx       = context.Background()
+		expiresAt = time.Now().Add(time.Hour)
+	)
+	store.GCInterval = -1
+
+	if err := store.CreateTable(ctx); err != nil || len(db.find(`CREATE TABLE IF NOT EXISTS "sessions"`)) != 1 {
+		t.Fatalf("Expected table to be created, but got %v %v", err, db.statements)
+	}
+
+	session := NewSessionData()
+	session.Set("user", "alice")
+	id, err := store.Save(ctx, session, expiresAt)
+	if err != nil || id != session.ID() {
+		t.Fatalf("Expected session to be saved with its ID, but got %v %v", id, err)
+	}
+
+	saved := db.find(`INSERT INTO "sessions"`)
+	if len(saved) != 1 || !strings.Contains(saved[0].query, "ON CONFLICT (id) DO UPDATE") || saved[0].args[0] != id || saved[0].args[2] != expiresAt {
+		t.Fatalf("Expected session to be upserted, but got %v", saved)
+	}
+
+	db.rows = func(string) ([]string, [][]driver.Value) {
+		return []string{"data"}, [][]driver.Value{{saved[0].args[1]}}
+	}
+	loaded, err := store.Load(ctx, id)
+	if err != nil || loaded == nil || loaded.ID() != id || loaded.GetString("user") != "alice" {
+		t.Errorf("Expected saved session to be loaded, but got %v %v", loaded, err)
+	}
+
+	if load := db.find("SELECT data"); len(load) != 1 || !strings.HasSuffix(load[0].query, "WHERE id = $1 AND expires_at > $2") || load[0].args[0] != id {
+		t.Errorf("Expected session to be loaded by ID unless expired, but got %v", load)
+	}
+
+	db.rows = nil
+	if loaded, err := store.Load(ctx, "unknown"); loaded != nil || err != nil {
+		t.Errorf("Expected no session for unknown ID, but got %v %v", loaded, err)
+	}
+
+	if err := store.Delete(ctx, id); err != nil || len(db.find(`DELETE FROM "sessions" WHERE id = $1`)) != 1 {
+		t.Errorf("Expected session to be deleted, but got %v", err)
+	}
+
+	if count, err := store.DeleteExpired(ctx); err != nil || count != 1 || len(db.find(`DELETE FROM "sessions" WHERE expires_at <= $1`)) != 1 {
+		t.Errorf("Expected expired sessions to be deleted, but got %v %v", count, err)
+	}
+
+	failure := errors.New("connection reset")
+	db.err = func(string) error { return failure }
+	if loaded, err := store.Load(ctx, id); loaded != nil || !errors.Is(err, failure) {
+		t.Errorf("Expected failed load to return error, but got %v %v", loaded, err)
+	}
+
+	if _, err := store.Save(ctx, session, expiresAt); !errors.Is(err, failure) {
+		t.Errorf("Expected failed save to return error, but got %v", err)
+	}
+}
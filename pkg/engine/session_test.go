@@ -0,0 +1,238 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sessionClient serves requests with handler, keeping the session cookie like a browser
+type sessionClient struct {
+	handler http.Handler
+	cookie  *http.Cookie
+}
+
+func (client *sessionClient) get(path string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", path, nil)
+	if client.cookie != nil {
+		req.AddCookie(client.cookie)
+	}
+	client.handler.ServeHTTP(recorder, req)
+
+	for _, cookie := range recorder.Result().Cookies() {
+		if cookie.Name == DefaultSessionCookieName {
+			if cookie.MaxAge < 0 {
+				client.cookie = nil
+			} else {
+				client.cookie = cookie
+			}
+		}
+	}
+	return recorder
+}
+
+func newSessionHandler(options SessionOptions) http.Handler {
+	stack := &MiddlewareStack{}
+	stack.Use(Session(options))
+
+	return stack.MustApply(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		session := GetSession(req)
+		switch req.URL.Path {
+		case "/login":
+			session.Renew()
+			session.Set("user", "alice")
+		case "/logout":
+			session.Destroy()
+		case "/flash":
+			session.Set("flash", "saved")
+			return
+		}
+
+		w.Write([]byte(session.GetString("user") + "|" + fmtValue(session.Pop("flash"))))
+	}))
+}
+
+func fmtValue(value interface{}) string {
+	s, _ := value.(string)
+	return s
+}
+
+func TestSession(t *testing.T) {
+	cookieStore, err := NewCookieSessionStore([]byte(strings.Repeat("k", 32)), []byte(strings.Repeat("b", 32)))
+	if err != nil {
+		t.Fatalf("Failed to create cookie store, got %v", err)
+	}
+
+	for name, store := range map[string]SessionStore{"memory": NewMemorySessionStore(), "cookie": cookieStore} {
+		client := &sessionClient{handler: newSessionHandler(SessionOptions{Store: store})}
+
+		if body := client.get("/").Body.String(); body != "|" || client.cookie != nil {
+			t.Errorf("Expected no session to be saved for %v, but got %v %v", name, body, client.cookie)
+		}
+
+		client.get("/flash")
+		if body := client.get("/").Body.String(); body != "|saved" {
+			t.Errorf("Expected flash message for %v, but got %v", name, body)
+		}
+
+		if body := client.get("/").Body.String(); body != "|" {
+			t.Errorf("Expected flash message to be shown once for %v, but got %v", name, body)
+		}
+
+		anonymous := client.cookie
+		client.get("/login")
+		if body := client.get("/").Body.String(); body != "alice|" || client.cookie == nil || client.cookie.Value == anonymous.Value {
+			t.Errorf("Expected logged in session with a new ID for %v, but got %v", name, body)
+		}
+
+		if name == "memory" {
+			if session, _ := store.Load(context.Background(), anonymous.Value); session != nil {
+				t.Errorf("Expected session before login to be deleted, but got %v", session.ID())
+			}
+		}
+
+		client.get("/logout")
+		if body := client.get("/").Body.String(); body != "|" || client.cookie != nil {
+			t.Errorf("Expected session to be destroyed for %v, but got %v %v", name, body, client.cookie)
+		}
+	}
+}
+
+func TestSessionStoreSharedByRebuilds(t *testing.T) {
+	factory, _ := DefaultRegistry.Lookup(SessionName)
+	registry := &Registry{}
+	registry.Register(SessionName, factory)
+
+	config, err := ParseConfig([]byte(`middlewares: [{name: session, params: {store: memory}}]`))
+	if err != nil {
+		t.Fatalf("Failed to parse config, got %v", err)
+	}
+
+	client := &sessionClient{}
+	for i, path := range []string{"/login", "/"} {
+		stack, err := registry.Build(config)
+		if err != nil {
+			t.Fatalf("Failed to build stack, got %v", err)
+		}
+
+		client.handler = stack.MustApply(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.URL.Path == "/login" {
+				GetSession(req).Set("user", "alice")
+			}
+			w.Write([]byte(GetSession(req).GetString("user")))
+		}))
+
+		if body := client.get(path).Body.String(); body != "alice" {
+			t.Errorf("Expected session to be kept by rebuilt stack %v, but got %q", i, body)
+		}
+	}
+}
+
+func TestSessionTimeouts(t *testing.T) {
+	var (
+		options = &SessionOptions{IdleTimeout: time.Minute, AbsoluteTimeout: time.Hour}
+		now     = time.Now()
+		session = &SessionData{createdAt: now.Add(-30 * time.Minute), lastSeen: now.Add(-30 * time.Second)}
+	)
+
+	if options.expired(session, now) {
+		t.Errorf("Expected session used 30 seconds ago not to be expired")
+	}
+
+	if !options.expired(session, now.Add(time.Minute)) {
+		t.Errorf("Expected session idle for 90 seconds to be expired")
+	}
+
+	session.lastSeen = now
+	if !options.expired(session, now.Add(31*time.Minute)) || options.expiresAt(session, now.Add(59*time.Minute)) != session.createdAt.Add(time.Hour) {
+		t.Errorf("Expected session to expire after absolute timeout")
+	}
+}
+
+func TestCookieSessionStoreTampering(t *testing.T) {
+	for _, blockKey := range []string{"", strings.Repeat("b", 16)} {
+		store, _ := NewCookieSessionStore([]byte(strings.Repeat("k", 32)), []byte(blockKey))
+
+		session := NewSessionData()
+		session.Set("user", "alice")
+		value, err := store.Save(context.Background(), session, time.Now().Add(time.Minute))
+		if err != nil {
+			t.Fatalf("Failed to save session, got %v", err)
+		}
+
+		if loaded, _ := store.Load(context.Background(), value); loaded == nil || loaded.GetString("user") != "alice" {
+			t.Errorf("Expected session to be loaded, but got %v", loaded)
+		}
+
+		tampered := "A" + value[1:]
+		if value[0] == 'A' {
+			tampered = "B" + value[1:]
+		}
+		if loaded, _ := store.Load(context.Background(), tampered); loaded != nil {
+			t.Errorf("Expected tampered session to be rejected")
+		}
+
+		expired, _ := store.Save(context.Background(), session, time.Now().Add(-time.Minute))
+		if loaded, _ := store.Load(context.Background(), expired); loaded != nil {
+			t.Errorf("Expected expired session to be rejected")
+		}
+	}
+
+	if _, err := NewCookieSessionStore([]byte("short"), nil); err == nil {
+		t.Errorf("Expected short hash key to be rejected")
+	}
+}
+
+func TestCSRFWithSession(t *testing.T) {
+	stack := &MiddlewareStack{}
+	stack.Use(CSRF(CSRFOptions{Mode: CSRFSynchronizer}))
+	stack.Use(Session(SessionOptions{Store: NewMemorySessionStore()}))
+
+	if str := stack.String(); str != "MiddlewareStack: session, csrf" {
+		t.Errorf("Expected session to run before csrf, but got %v", str)
+	}
+
+	var token string
+	client := &sessionClient{handler: stack.MustApply(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		token = CSRFToken(req)
+	}))}
+	client.get("/")
+
+	if client.cookie == nil || token == "" {
+		t.Fatalf("Expected CSRF token to be saved in a new session, but got %v", client.cookie)
+	}
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/", nil)
+	req.AddCookie(client.cookie)
+	req.Header.Set(DefaultCSRFHeaderName, token)
+	client.handler.ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected token of the session to be accepted, but got %v", recorder.Code)
+	}
+}
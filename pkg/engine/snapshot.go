@@ -0,0 +1,91 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// stackSnapshot immutable state of a MiddlewareStack, writers never change a
+// published snapshot, they publish a new one instead
+type stackSnapshot struct {
+	middlewares []*Middleware
+
+	sortOnce sync.Once
+	sorted   []*Middleware
+	err      error
+}
+
+// sortMiddlewares sort the snapshot's middlewares once, later calls share the result
+func (snapshot *stackSnapshot) sortMiddlewares() ([]*Middleware, error) {
+	snapshot.sortOnce.Do(func() {
+		orderings := make([]ordering, len(snapshot.middlewares))
+		for idx, middleware := range snapshot.middlewares {
+			orderings[idx] = middleware.ordering()
+		}
+
+		sorted, err := sortOrderings(orderings)
+		if err != nil {
+			snapshot.err = err
+			return
+		}
+
+		for _, idx := range sorted {
+			snapshot.sorted = append(snapshot.sorted, snapshot.middlewares[idx])
+		}
+	})
+
+	return snapshot.sorted, snapshot.err
+}
+
+// emptySnapshot snapshot of a stack that was never changed
+var emptySnapshot = &stackSnapshot{}
+
+// snapshots copy-on-write holder of stack snapshots, the zero value is an empty stack
+type snapshots struct {
+	mu    sync.Mutex
+	value atomic.Value
+}
+
+// load returns the current snapshot, it never blocks
+func (s *snapshots) load() *stackSnapshot {
+	if snapshot, ok := s.value.Load().(*stackSnapshot); ok {
+		return snapshot
+	}
+	return emptySnapshot
+}
+
+// update publishes the middlewares returned by fn as a new snapshot, fn gets
+// a copy of the current middlewares, so it could change it freely. Writers
+// are serialized, readers keep using the previous snapshot until it is stored
+func (s *snapshots) update(fn func(middlewares []*Middleware) ([]*Middleware, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	middlewares, err := fn(append([]*Middleware{}, s.load().middlewares...))
+	if err != nil {
+		return err
+	}
+
+	s.value.Store(&stackSnapshot{middlewares: middlewares})
+	return nil
+}
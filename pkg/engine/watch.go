@@ -0,0 +1,131 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultWatchInterval default polling interval of ConfigWatcher
+const DefaultWatchInterval = 2 * time.Second
+
+// ConfigWatcher reloads a stack configuration file into Stack whenever it
+// changes. The file is polled, so it also follows files replaced by renames,
+// like mounted Kubernetes ConfigMaps. A configuration that fails to build is
+// rejected and logged, Stack keeps its last valid middlewares, a valid one is
+// published as a single snapshot, so handlers from Stack.ApplyLive switch to
+// it atomically
+type ConfigWatcher struct {
+	Path     string
+	Stack    *MiddlewareStack
+	Registry *Registry
+	Interval time.Duration
+	Logger   log.FieldLogger
+
+	data []byte
+}
+
+// Load load the configuration file into Stack, returns an error if it is invalid
+func (watcher *ConfigWatcher) Load() error {
+	data, err := os.ReadFile(watcher.Path)
+	if err != nil {
+		return err
+	}
+	return watcher.load(data)
+}
+
+func (watcher *ConfigWatcher) load(data []byte) error {
+	config, err := ParseConfig(data)
+	if err != nil {
+		return err
+	}
+
+	registry := watcher.Registry
+	if registry == nil {
+		registry = DefaultRegistry
+	}
+
+	stack, err := registry.Build(config)
+	if err != nil {
+		return err
+	}
+
+	watcher.data = data
+	watcher.Stack.swap(stack)
+	return nil
+}
+
+// Run polls the configuration file until ctx is done, it should be called after a successful Load
+func (watcher *ConfigWatcher) Run(ctx context.Context) {
+	interval := watcher.Interval
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			watcher.reload()
+		}
+	}
+}
+
+// reload reloads the configuration file if its content changed
+func (watcher *ConfigWatcher) reload() {
+	logger := watcher.logger()
+
+	data, err := os.ReadFile(watcher.Path)
+	if err != nil {
+		logger.WithError(err).Warn("cannot read middleware config, keeping current middlewares")
+		return
+	}
+
+	if bytes.Equal(data, watcher.data) {
+		return
+	}
+
+	if err := watcher.load(data); err != nil {
+		// remember the rejected content, so it is reported once until it changes again
+		watcher.data = data
+		logger.WithError(err).Error("invalid middleware config, keeping current middlewares")
+		return
+	}
+
+	logger.WithField("middlewares", watcher.Stack.Names()).Info("middleware config reloaded")
+}
+
+func (watcher *ConfigWatcher) logger() log.FieldLogger {
+	logger := watcher.Logger
+	if logger == nil {
+		logger = log.StandardLogger()
+	}
+	return logger.WithField("config", watcher.Path)
+}